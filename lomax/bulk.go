@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// The modes of a bulk step, see WorkloadStep.Bulk.
+const (
+	bulkInsert  = "insert"
+	bulkUpsert  = "upsert"
+	bulkReplace = "replace"
+	bulkInfile  = "infile"
+)
+
+// defaultBatch is the number of rows per statement of a bulk step.
+const defaultBatch = 1000
+
+// maxPlaceholders is the most ? placeholders a prepared statement may hold.
+const maxPlaceholders = 65535
+
+var bulkPtr string
+var batchPtr int
+
+// bulkBuffer holds the bulk report, one row per bulk step.
+var bulkBuffer [][]string
+var bulkHeader = []string{"Bulk Step", "Mode", "Batch", "Statements", "Rows", "Rows/sec", "Bytes", "Bytes/sec", "Errors"}
+
+// infileReaders numbers the readers registered for LOAD DATA LOCAL INFILE.
+var infileReaders int64
+
+func init() {
+	flag.StringVar(&bulkPtr, "bulk", "", "Write --batch generated rows per INSERT: insert (multi-row INSERT), upsert (INSERT ... ON DUPLICATE KEY UPDATE), replace (REPLACE) or infile (LOAD DATA LOCAL INFILE).")
+	flag.IntVar(&batchPtr, "batch", 0, fmt.Sprintf("Rows per statement of --bulk inserts, %d by default.", defaultBatch))
+}
+
+// validateBulk checks the bulk mode and batch of a step and fills in their
+// defaults.  Bulk steps always generate their rows.
+func (step *WorkloadStep) validateBulk(label string) error {
+	if step.Bulk == "" && step.Batch == 0 {
+		return nil
+	}
+	step.Bulk = strings.ToLower(step.Bulk)
+	if step.Bulk == "" {
+		step.Bulk = bulkInsert
+	}
+	switch step.Bulk {
+	case bulkInsert, bulkUpsert, bulkReplace, bulkInfile:
+	default:
+		return fmt.Errorf("%s: invalid bulk mode %q, must be %q, %q, %q or %q", label, step.Bulk, bulkInsert, bulkUpsert, bulkReplace, bulkInfile)
+	}
+	if step.Query != "" || step.Transaction != nil || strings.ToUpper(step.Action) != "INSERT" {
+		return fmt.Errorf("%s: bulk steps must be INSERT actions", label)
+	}
+	if len(step.Params) > 0 {
+		return fmt.Errorf("%s: bulk steps take no params", label)
+	}
+	if step.Batch < 0 {
+		return fmt.Errorf("%s: invalid batch %d", label, step.Batch)
+	}
+	if step.Batch == 0 {
+		step.Batch = defaultBatch
+	}
+	step.Random = true
+	return nil
+}
+
+// bulkQuery returns the statement of a bulk step that writes rows rows
+// through ? placeholders.  LOAD DATA statements are built by execInfile.
+func (step *WorkloadStep) bulkQuery(rows int) string {
+	gen := step.generator
+	row := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(gen.columns)), ", ") + ")"
+	values := strings.TrimSuffix(strings.Repeat(row+", ", rows), ", ")
+
+	verb := "INSERT"
+	if step.Bulk == bulkReplace {
+		verb = "REPLACE"
+	}
+	query := fmt.Sprintf("%s %s INTO %s (%s) VALUES %s", verb, step.Flag, step.Table, gen.columnList(), values)
+	if step.Bulk == bulkUpsert {
+		query += " ON DUPLICATE KEY UPDATE " + gen.updateList()
+	}
+	return query
+}
+
+// updateList returns the assignments of an upsert: every generated column
+// outside the primary key takes the value that was to be inserted.
+func (gen *rowGenerator) updateList() string {
+	var assignments []string
+	for _, column := range gen.columns {
+		if !column.primary {
+			assignments = append(assignments, fmt.Sprintf("`%s` = VALUES(`%s`)", column.name, column.name))
+		}
+	}
+	if len(assignments) == 0 {
+		name := gen.columns[0].name
+		assignments = append(assignments, fmt.Sprintf("`%s` = VALUES(`%s`)", name, name))
+	}
+	return strings.Join(assignments, ", ")
+}
+
+// prepareBulk builds the statement of a bulk step, once its generator is
+// set.
+func (step *WorkloadStep) prepareBulk() error {
+	if step.Bulk == bulkInfile {
+		return nil
+	}
+	if placeholders := step.Batch * len(step.generator.columns); placeholders > maxPlaceholders {
+		return fmt.Errorf("a batch of %d rows of %s needs %d placeholders, more than the %d MySQL allows", step.Batch, step.Table, placeholders, maxPlaceholders)
+	}
+	step.query = step.bulkQuery(step.Batch)
+	return nil
+}
+
+// execBulk executes a bulk step once, writing rows generated rows, within tx
+// unless it is nil.  The sample holds the rows sent and the bytes of row data
+// they amount to in the format of LOAD DATA.
+func (w *worker) execBulk(tx *sql.Tx, index int, step *WorkloadStep, rows int) (sample, error) {
+	if step.Bulk == bulkInfile {
+		return w.execInfile(tx, step, rows)
+	}
+
+	result := sample{rows: int64(rows)}
+	args := w.args[:0]
+	for i := 0; i < rows; i++ {
+		values := step.generator.row(w.rng)
+		result.bytes += int64(len(infileRow(values)))
+		args = append(args, values...)
+	}
+	w.args = args
+
+	var err error
+	switch {
+	case w.prepared && rows == step.Batch:
+		var stmt *sql.Stmt
+		if stmt, err = w.statementIn(tx, index, step); err != nil {
+			return sample{}, err
+		}
+		_, err = stmt.Exec(args...)
+	case tx != nil:
+		_, err = tx.Exec(step.bulkQuery(rows), args...)
+	default:
+		_, err = w.dbFor(step).Exec(step.bulkQuery(rows), args...)
+	}
+	if err != nil {
+		return sample{}, err
+	}
+	return result, nil
+}
+
+// execInfile writes rows generated rows with LOAD DATA LOCAL INFILE, streamed
+// from memory through a reader handler of the driver.  The server must allow
+// local_infile.
+func (w *worker) execInfile(tx *sql.Tx, step *WorkloadStep, rows int) (sample, error) {
+	var data bytes.Buffer
+	for i := 0; i < rows; i++ {
+		data.WriteString(infileRow(step.generator.row(w.rng)))
+	}
+	result := sample{rows: int64(rows), bytes: int64(data.Len())}
+
+	name := fmt.Sprintf("lomax-%d", atomic.AddInt64(&infileReaders, 1))
+	mysql.RegisterReaderHandler(name, func() io.Reader {
+		return bytes.NewReader(data.Bytes())
+	})
+	defer mysql.DeregisterReaderHandler(name)
+
+	query := fmt.Sprintf("LOAD DATA LOCAL INFILE 'Reader::%s' INTO TABLE %s (%s)", name, step.Table, step.generator.columnList())
+	var err error
+	if tx != nil {
+		_, err = tx.Exec(query)
+	} else {
+		_, err = w.dbFor(step).Exec(query)
+	}
+	if err != nil {
+		return sample{}, err
+	}
+	return result, nil
+}
+
+// infileRow formats a generated row in the default format of LOAD DATA: tab
+// separated fields, backslash escapes, \N for NULL and a newline at the end.
+func infileRow(values []interface{}) string {
+	fields := make([]string, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case nil:
+			fields[i] = `\N`
+		case int64:
+			fields[i] = strconv.FormatInt(v, 10)
+		case float64:
+			fields[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			fields[i] = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\x00", `\0`).Replace(fmt.Sprintf("%v", v))
+		}
+	}
+	return strings.Join(fields, "\t") + "\n"
+}
+
+// collectBulk adds the report row of a bulk step that took elapsed.
+func collectBulk(name string, step *WorkloadStep, elapsed time.Duration, stats *stepStats) {
+	rowRate, byteRate := "-", "-"
+	if elapsed > 0 {
+		rowRate = fmt.Sprintf("%.0f", float64(stats.rows)/elapsed.Seconds())
+		byteRate = fmt.Sprintf("%.0f", float64(stats.bytes)/elapsed.Seconds())
+	}
+	bulkBuffer = append(bulkBuffer, []string{name, step.Bulk, fmt.Sprintf("%d", step.Batch), fmt.Sprintf("%d", stats.iterations), fmt.Sprintf("%d", stats.rows), rowRate,
+		fmt.Sprintf("%d", stats.bytes), byteRate, fmt.Sprintf("%d", stats.errors)})
+	resultDoc.Bulk = append(resultDoc.Bulk, newBulkRecord(name, step, elapsed, stats))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateBulk(t *testing.T) {
+	cases := []struct {
+		step      WorkloadStep
+		wantError bool
+	}{
+		{WorkloadStep{Action: "INSERT", Table: "t"}, false},
+		{WorkloadStep{Action: "insert", Table: "t", Bulk: "UPSERT"}, false},
+		{WorkloadStep{Action: "INSERT", Table: "t", Batch: 50}, false},
+		{WorkloadStep{Action: "INSERT", Table: "t", Bulk: "copy"}, true},
+		{WorkloadStep{Action: "UPDATE", Table: "t", Bulk: "insert"}, true},
+		{WorkloadStep{Query: "INSERT INTO t VALUES (1)", Bulk: "insert"}, true},
+		{WorkloadStep{Action: "INSERT", Table: "t", Bulk: "insert", Batch: -1}, true},
+		{WorkloadStep{Action: "INSERT", Table: "t", Bulk: "insert", Params: []GeneratorSpec{{Type: "uniform"}}}, true},
+	}
+	for _, c := range cases {
+		step := c.step
+		err := step.validateBulk("step 1")
+		if (err != nil) != c.wantError {
+			t.Errorf("validateBulk(%+v) returned error %v, want error %t", c.step, err, c.wantError)
+			continue
+		}
+		if err == nil && c.step.Bulk == "" && c.step.Batch == 0 && step.Bulk != "" {
+			t.Errorf("validateBulk(%+v) turned a single row step into %q", c.step, step.Bulk)
+		}
+		if err == nil && step.Bulk != "" && (step.Batch <= 0 || !step.Random) {
+			t.Errorf("validateBulk(%+v) = %+v, want a batch of generated rows", c.step, step)
+		}
+	}
+}
+
+func TestBulkQuery(t *testing.T) {
+	gen := &rowGenerator{table: "t", columns: []columnInfo{{name: "id", primary: true}, {name: "v"}}}
+	cases := map[string]string{
+		bulkInsert:  "INSERT IGNORE INTO t (`id`, `v`) VALUES (?, ?), (?, ?)",
+		bulkReplace: "REPLACE IGNORE INTO t (`id`, `v`) VALUES (?, ?), (?, ?)",
+		bulkUpsert:  "INSERT IGNORE INTO t (`id`, `v`) VALUES (?, ?), (?, ?) ON DUPLICATE KEY UPDATE `v` = VALUES(`v`)",
+	}
+	for mode, want := range cases {
+		step := WorkloadStep{Action: "INSERT", Flag: "IGNORE", Table: "t", Bulk: mode, generator: gen}
+		if got := step.bulkQuery(2); got != want {
+			t.Errorf("bulkQuery(2) in %s mode = %q, want %q", mode, got, want)
+		}
+	}
+
+	step := WorkloadStep{Table: "t", Bulk: bulkInsert, Batch: 40000, generator: gen}
+	if err := step.prepareBulk(); err == nil || !strings.Contains(err.Error(), "placeholders") {
+		t.Errorf("prepareBulk() of 80000 placeholders returned %v, want an error", err)
+	}
+}
+
+func TestInfileRow(t *testing.T) {
+	got := infileRow([]interface{}{int64(7), 2.5, nil, "a\tb\\c\nd"})
+	if want := "7\t2.5\t\\N\ta\\tb\\\\c\\nd\n"; got != want {
+		t.Errorf("infileRow() = %q, want %q", got, want)
+	}
+}
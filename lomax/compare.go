@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/opendns/lemming/lib/log"
+)
+
+var thresholdPtr float64
+
+// resultHeaders are the headers of the result tables compare reads, the
+// report headers of the other runs and of a test vector run.
+var resultHeaders = [][]string{benchHeader, vectorHeader}
+
+// otherHeaders are the headers of the other tables of a result file, whose
+// rows compare skips.
+var otherHeaders = [][]string{transactionHeader, errorHeader, intervalHeader, lagHeader, lagSummaryHeader, statusHeader, variablesHeader, statusIntervalHeader, replayHeader, bulkHeader, ddlHeader}
+
+// compareMetrics are the latency columns compare checks.  Throughput is
+// always checked.
+var compareMetrics = []string{"Mean", "P50", "P90", "P99", "P99.9"}
+
+func init() {
+	flag.Float64Var(&thresholdPtr, "threshold", 5, "lomax compare: the change in percent of throughput or a latency percentile that counts as a regression or improvement.")
+}
+
+// benchResult is a row of a result file.
+type benchResult struct {
+	name string
+	// result is PASS or FAIL for test vector runs, empty otherwise.
+	result     string
+	elapsed    time.Duration
+	iterations int
+	errors     int
+	latency    map[string]time.Duration
+}
+
+// throughput returns the queries per second of the result, or 0 if it is
+// unknown.
+func (r *benchResult) throughput() float64 {
+	if r.elapsed <= 0 {
+		return 0
+	}
+	return float64(r.iterations) / r.elapsed.Seconds()
+}
+
+// isCompare reports whether lomax was run as "lomax compare".
+func isCompare(args []string) bool {
+	return len(args) >= 1 && args[0] == "compare"
+}
+
+// parseInterspersed parses the flags in args, which may follow the
+// positional arguments, and returns the positional arguments.
+func parseInterspersed(args []string) []string {
+	var positional []string
+	for {
+		flag.CommandLine.Parse(args)
+		args = flag.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// readResults reads the results of a CSV or JSON file written with
+// --logprefix.  Results are keyed by name, order lists the names as they
+// appear in the file.
+func readResults(path string) (results map[string]*benchResult, order []string, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows [][]string
+	switch {
+	case strings.HasSuffix(path, ".csv") || strings.Contains(path, ".csv."):
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.FieldsPerRecord = -1
+		rows, err = reader.ReadAll()
+		results, order = parseResults(rows)
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")):
+		var doc resultDocument
+		if err = json.Unmarshal(data, &doc); err == nil {
+			results, order, err = documentResults(doc)
+		}
+	default:
+		// JSON exports before the result document were tables.
+		err = json.Unmarshal(data, &rows)
+		results, order = parseResults(rows)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	if len(results) == 0 {
+		return nil, nil, fmt.Errorf("%s: no results found", path)
+	}
+	return results, order, nil
+}
+
+// documentResults returns the results of a JSON result document.
+func documentResults(doc resultDocument) (map[string]*benchResult, []string, error) {
+	if doc.Schema != resultSchema || doc.Version < 1 || doc.Version > resultSchemaVersion {
+		return nil, nil, fmt.Errorf("unsupported result schema %q version %d", doc.Schema, doc.Version)
+	}
+	results := make(map[string]*benchResult)
+	var order []string
+	for _, record := range doc.Results {
+		r := &benchResult{
+			name:       record.Name,
+			result:     record.Result,
+			elapsed:    time.Duration(record.ElapsedSeconds * float64(time.Second)),
+			iterations: record.Iterations,
+			errors:     record.Errors,
+			latency:    make(map[string]time.Duration),
+		}
+		if l := record.LatencyMs; l != nil {
+			for metric, ms := range map[string]float64{"Mean": l.Mean, "P50": l.P50, "P90": l.P90, "P99": l.P99, "P99.9": l.P999} {
+				r.latency[metric] = time.Duration(ms * float64(time.Millisecond))
+			}
+		}
+		if _, ok := results[r.name]; !ok {
+			order = append(order, r.name)
+		}
+		results[r.name] = r
+	}
+	return results, order, nil
+}
+
+// parseResults picks the result rows out of the tables of a CSV or legacy
+// JSON result file.  Legacy JSON files without a header hold the rows of a
+// plain run first.
+func parseResults(rows [][]string) (map[string]*benchResult, []string) {
+	results := make(map[string]*benchResult)
+	var order []string
+	columns := columnIndex(resultHeaders[0])
+	for _, row := range rows {
+		if header := matchHeader(row, resultHeaders); header != nil {
+			columns = columnIndex(header)
+			continue
+		}
+		if matchHeader(row, otherHeaders) != nil {
+			columns = nil
+			continue
+		}
+		if columns == nil || len(row) == 0 {
+			continue
+		}
+		r := newBenchResult(row, columns)
+		if _, ok := results[r.name]; !ok {
+			order = append(order, r.name)
+		}
+		results[r.name] = r
+	}
+	return results, order
+}
+
+// matchHeader returns the header of headers that row is, in its printed or
+// CSV form, or nil.
+func matchHeader(row []string, headers [][]string) []string {
+	got := csvHeader(row)
+	for _, header := range headers {
+		want := csvHeader(header)
+		match := len(got) == len(want)
+		for i := 0; match && i < len(want); i++ {
+			match = got[i] == want[i]
+		}
+		if match {
+			return header
+		}
+	}
+	return nil
+}
+
+// columnIndex maps the CSV form of the columns of header to their index.
+func columnIndex(header []string) map[string]int {
+	columns := make(map[string]int)
+	for i, name := range csvHeader(header) {
+		columns[name] = i
+	}
+	return columns
+}
+
+// newBenchResult converts a result row.  Columns that do not parse, like the
+// "-" of unmeasured values, are left out.
+func newBenchResult(row []string, columns map[string]int) *benchResult {
+	cell := func(name string) string {
+		if i, ok := columns[csvHeader([]string{name})[0]]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	r := &benchResult{name: row[0], result: cell("Result"), latency: make(map[string]time.Duration)}
+	r.elapsed, _ = time.ParseDuration(cell("Time Taken"))
+	r.iterations, _ = strconv.Atoi(cell("Iterations"))
+	r.errors, _ = strconv.Atoi(cell("Errors"))
+	for _, metric := range compareMetrics {
+		if d, err := time.ParseDuration(cell(metric)); err == nil {
+			r.latency[metric] = d
+		}
+	}
+	return r
+}
+
+// percentChange returns the change from old to new in percent, and false
+// if it can not be computed.
+func percentChange(old float64, new float64) (float64, bool) {
+	if old <= 0 || new <= 0 {
+		return 0, false
+	}
+	return 100 * (new - old) / old, true
+}
+
+// comparison is the outcome of comparing a result with its baseline.
+type comparison struct {
+	columns     []string
+	regressions []string
+	improved    bool
+}
+
+// compareResult compares a result with its baseline.  Throughput must not
+// drop, and latencies must not rise, by more than threshold percent.
+func compareResult(old *benchResult, new *benchResult, threshold float64) comparison {
+	c := comparison{columns: []string{fmt.Sprintf("%.1f", old.throughput()), fmt.Sprintf("%.1f", new.throughput())}}
+	format := func(change float64, ok bool) string {
+		if !ok {
+			return "-"
+		}
+		return fmt.Sprintf("%+.1f%%", change)
+	}
+
+	change, ok := percentChange(old.throughput(), new.throughput())
+	c.columns = append(c.columns, format(change, ok))
+	if ok && change < -threshold {
+		c.regressions = append(c.regressions, "throughput")
+	}
+	c.improved = ok && change > threshold
+
+	for _, metric := range compareMetrics {
+		change, ok := percentChange(float64(old.latency[metric]), float64(new.latency[metric]))
+		c.columns = append(c.columns, format(change, ok))
+		if ok && change > threshold {
+			c.regressions = append(c.regressions, metric)
+		}
+		c.improved = c.improved || (ok && change < -threshold)
+	}
+
+	if new.errors > old.errors {
+		c.regressions = append(c.regressions, "errors")
+	}
+	if old.result == "PASS" && new.result == "FAIL" {
+		c.regressions = append(c.regressions, "failed")
+	}
+	c.columns = append(c.columns, fmt.Sprintf("%d", new.errors-old.errors))
+	return c
+}
+
+// runCompare compares the results of two result files and prints the
+// differences.  It returns the exit status: 1 if any result regressed.
+func runCompare(args []string) int {
+	if len(args) != 2 {
+		log.Error("[%s]: usage: lomax compare [--threshold=percent] old-results new-results", GetFunctionName(runCompare))
+	}
+	if thresholdPtr < 0 || math.IsNaN(thresholdPtr) {
+		log.Error("Please specify a positive --threshold.")
+	}
+	oldResults, oldOrder, err := readResults(args[0])
+	if err != nil {
+		log.Error("[%s]: %s", GetFunctionName(runCompare), err.Error())
+	}
+	newResults, newOrder, err := readResults(args[1])
+	if err != nil {
+		log.Error("[%s]: %s", GetFunctionName(runCompare), err.Error())
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(append(append([]string{"Name", "Old Queries/sec", "New Queries/sec", "Throughput"}, compareMetrics...), "Errors", "Verdict"))
+	regressions := 0
+	for _, name := range oldOrder {
+		newResult, ok := newResults[name]
+		if !ok {
+			table.Append([]string{name, fmt.Sprintf("%.1f", oldResults[name].throughput()), "-", "-", "-", "-", "-", "-", "-", "-", "only in old"})
+			continue
+		}
+		c := compareResult(oldResults[name], newResult, thresholdPtr)
+		verdict := "-"
+		if len(c.regressions) > 0 {
+			verdict = "REGRESSION: " + strings.Join(c.regressions, ", ")
+			regressions++
+		} else if c.improved {
+			verdict = "improvement"
+		}
+		table.Append(append(append([]string{name}, c.columns...), verdict))
+	}
+	for _, name := range newOrder {
+		if _, ok := oldResults[name]; !ok {
+			table.Append([]string{name, "-", fmt.Sprintf("%.1f", newResults[name].throughput()), "-", "-", "-", "-", "-", "-", "-", "only in new"})
+		}
+	}
+	table.Render()
+
+	if regressions > 0 {
+		fmt.Println(fmt.Sprintf("%d of %d results regressed by more than %g%%", regressions, len(oldOrder), thresholdPtr))
+		return 1
+	}
+	fmt.Println(fmt.Sprintf("No result regressed by more than %g%%", thresholdPtr))
+	return 0
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseResults(t *testing.T) {
+	rows := [][]string{
+		// Older JSON exports start without a header.
+		{"main.selectBenchmark", "2s", "1000", "-", "-", "1000", "5000", "0", "100µs", "1ms", "900µs", "1.5ms", "3ms", "5ms", "9ms"},
+		transactionHeader,
+		{"[1] Transfer", "10", "10", "0", "100.0%", "0", "0", "0", "0", "1ms", "2ms", "2ms", "3ms", "3ms", "3ms", "3ms"},
+		csvHeader(vectorHeader),
+		{"employees", "FAIL", "1s", "100", "-", "-", "2", "-", "-", "-", "-", "-", "-", "-", "error"},
+	}
+	results, order := parseResults(rows)
+	if !reflect.DeepEqual(order, []string{"main.selectBenchmark", "employees"}) {
+		t.Fatalf("parseResults() order = %q", order)
+	}
+	r := results["main.selectBenchmark"]
+	if r.iterations != 1000 || r.elapsed != 2*time.Second || r.throughput() != 500 || r.latency["P99"] != 3*time.Millisecond {
+		t.Errorf("parseResults() = %+v", r)
+	}
+	if r := results["employees"]; r.result != "FAIL" || r.errors != 2 || len(r.latency) != 0 {
+		t.Errorf("parseResults() vector row = %+v", r)
+	}
+}
+
+func TestReadResults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lomax")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "baseline.csv.1500000000")
+	data := strings.Join(csvHeader(benchHeader), ",") + "\n" +
+		"[1] Hire,1s,200,-,-,200,0,0,1ms,2ms,2ms,3ms,4ms,5ms,6ms\n\n" +
+		strings.Join(csvHeader(errorHeader), ",") + "\n" +
+		"[1] Hire,0,0,0,0,0,0,0,0,0\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results, order, err := readResults(path)
+	if err != nil {
+		t.Fatalf("readResults() returned error %v", err)
+	}
+	if len(order) != 1 || results["[1] Hire"].iterations != 200 {
+		t.Errorf("readResults() = %q, %+v", order, results["[1] Hire"])
+	}
+}
+
+func TestCompareResult(t *testing.T) {
+	latency := func(p50 time.Duration, p99 time.Duration) map[string]time.Duration {
+		return map[string]time.Duration{"P50": p50, "P99": p99}
+	}
+	old := &benchResult{name: "step", elapsed: time.Second, iterations: 1000, latency: latency(time.Millisecond, 10*time.Millisecond)}
+
+	cases := []struct {
+		new         *benchResult
+		regressions []string
+		improved    bool
+	}{
+		{&benchResult{elapsed: time.Second, iterations: 980, latency: latency(time.Millisecond, 10400*time.Microsecond)}, nil, false},
+		{&benchResult{elapsed: time.Second, iterations: 900, latency: latency(time.Millisecond, 12*time.Millisecond)}, []string{"throughput", "P99"}, false},
+		{&benchResult{elapsed: time.Second, iterations: 1200, latency: latency(800*time.Microsecond, 10*time.Millisecond), errors: 1}, []string{"errors"}, true},
+	}
+	for i, c := range cases {
+		got := compareResult(old, c.new, 5)
+		if !reflect.DeepEqual(got.regressions, c.regressions) || got.improved != c.improved {
+			t.Errorf("case %d: compareResult() regressions %q, improved %v, want %q, %v", i, got.regressions, got.improved, c.regressions, c.improved)
+		}
+	}
+
+	if got := compareResult(&benchResult{result: "PASS"}, &benchResult{result: "FAIL"}, 5); !reflect.DeepEqual(got.regressions, []string{"failed"}) {
+		t.Errorf("compareResult() of a failed test case = %q", got.regressions)
+	}
+}
+
+func TestParseInterspersed(t *testing.T) {
+	threshold := thresholdPtr
+	defer func() { thresholdPtr = threshold }()
+
+	args := parseInterspersed([]string{"old.json", "--threshold=10", "new.json"})
+	if !reflect.DeepEqual(args, []string{"old.json", "new.json"}) || thresholdPtr != 10 {
+		t.Errorf("parseInterspersed() = %q with threshold %v", args, thresholdPtr)
+	}
+}
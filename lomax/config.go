@@ -0,0 +1,516 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/opendns/lemming/lib/log"
+)
+
+// Configuration is merged from several layers, each overriding the ones
+// before it:
+//
+//	built-in defaults < system config < user config < --config file <
+//	workload or vector file < LOMAX_* environment variables < flags
+//
+// Every layer is decoded into a Config, whose options are named after the
+// flags, so any command line option can be given in any layer, e.g.
+// {"hostname": "db1", "threads": 16, "duration": "5m"}.
+
+// systemConfigFile is the system wide config layer.
+var systemConfigFile = "/etc/lomax/lomax.json"
+
+// configMetadata are the config file keys that describe the file instead of
+// setting an option.
+var configMetadata = map[string]bool{"name": true, "tier": true, "prod": true}
+
+// Config holds the options one layer sets, each named by the flag it sets.
+// Options the layer leaves alone are nil.
+type Config struct {
+	// Connection
+	Hostname     *string   `json:"hostname"`
+	Port         *string   `json:"port"`
+	Socket       *string   `json:"socket"`
+	DSN          *string   `json:"dsn"`
+	User         *string   `json:"user"`
+	Password     *string   `json:"password"`
+	PasswordFile *string   `json:"passwordfile"`
+	DefaultsFile *string   `json:"defaults-file"`
+	DB           *string   `json:"db"`
+	TLS          *string   `json:"tls"`
+	TLSCA        *string   `json:"tlsca"`
+	TLSCert      *string   `json:"tlscert"`
+	TLSKey       *string   `json:"tlskey"`
+	Charset      *string   `json:"charset"`
+	Collation    *string   `json:"collation"`
+	Timeout      *duration `json:"timeout"`
+	ReadTimeout  *duration `json:"readtimeout"`
+	WriteTimeout *duration `json:"writetimeout"`
+	Session      *string   `json:"session"`
+	Transports   *string   `json:"transports"`
+	Targets      *string   `json:"targets"`
+	Fanout       *bool     `json:"fanout"`
+	Split        *bool     `json:"split"`
+	LagPoll      *duration `json:"lagpoll"`
+	MaxOpen      *int      `json:"maxopen"`
+	MaxIdle      *int      `json:"maxidle"`
+	ConnLifetime *duration `json:"connlifetime"`
+	Storm        *int      `json:"storm"`
+
+	// What to run
+	ConfigFile   *string  `json:"config"`
+	VectorFile   *string  `json:"vector"`
+	WorkloadFile *string  `json:"workload"`
+	ReplayFile   *string  `json:"replay"`
+	ReplayFormat *string  `json:"replayformat"`
+	ReplaySpeed  *float64 `json:"replayspeed"`
+	Operation    *string  `json:"operation"`
+	Table        *string  `json:"table"`
+	Condition    *string  `json:"condition"`
+	Params       *string  `json:"params"`
+	Columns      *string  `json:"cols"`
+	Flag         *string  `json:"flag"`
+	Random       *string  `json:"random"`
+	Bulk         *string  `json:"bulk"`
+	Batch        *int     `json:"batch"`
+	Prepared     *bool    `json:"prepared"`
+
+	// How long and how hard
+	Threads  *float64  `json:"threads"`
+	Count    *float64  `json:"count"`
+	Duration *duration `json:"duration"`
+	Rate     *float64  `json:"rate"`
+	OnError  *string   `json:"onerror"`
+	Retries  *int      `json:"retries"`
+	Backoff  *duration `json:"backoff"`
+	MDLPoll  *duration `json:"mdlpoll"`
+
+	// Reports
+	LogPrefix       *string   `json:"logprefix"`
+	LogType         *string   `json:"logtype"`
+	Interval        *duration `json:"interval"`
+	ServerStatus    *bool     `json:"serverstatus"`
+	IntervalStatus  *bool     `json:"intervalstatus"`
+	StatusCounters  *string   `json:"statuscounters"`
+	StatusVariables *string   `json:"statusvariables"`
+	Threshold       *float64  `json:"threshold"`
+}
+
+// duration is a Config duration, given as a string such as "5m".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func (d duration) String() string {
+	return time.Duration(d).String()
+}
+
+// configFields maps every option to the index of its field in Config.
+var configFields = make(map[string]int)
+
+func init() {
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		configFields[t.Field(i).Tag.Get("json")] = i
+	}
+}
+
+// configKinds describes the values every type of option takes.
+var configKinds = map[reflect.Type]string{
+	reflect.TypeOf(""):          "a string",
+	reflect.TypeOf(false):       "a boolean",
+	reflect.TypeOf(0):           "a whole number",
+	reflect.TypeOf(0.0):         "a number",
+	reflect.TypeOf(duration(0)): "a duration such as \"5m\"",
+}
+
+// set sets an option of the config from its text, as given in the
+// environment.
+func (config *Config) set(name string, text string) error {
+	field := reflect.ValueOf(config).Elem().Field(configFields[name])
+	value := reflect.New(field.Type().Elem())
+	var err error
+	switch target := value.Interface().(type) {
+	case *string:
+		*target = text
+	case *bool:
+		*target, err = strconv.ParseBool(text)
+	case *int:
+		*target, err = strconv.Atoi(text)
+	case *float64:
+		*target, err = strconv.ParseFloat(text, 64)
+	case *duration:
+		var parsed time.Duration
+		parsed, err = time.ParseDuration(text)
+		*target = duration(parsed)
+	}
+	if err != nil {
+		return fmt.Errorf("%s must be %s, not %q", name, configKinds[field.Type().Elem()], text)
+	}
+	field.Set(value)
+	return nil
+}
+
+// values returns the text of every option the config sets, keyed by flag
+// name.
+func (config *Config) values() map[string]string {
+	values := make(map[string]string)
+	fields := reflect.ValueOf(config).Elem()
+	for name, index := range configFields {
+		if field := fields.Field(index); !field.IsNil() {
+			values[name] = fmt.Sprintf("%v", field.Elem().Interface())
+		}
+	}
+	return values
+}
+
+// validate returns every problem of the options the config sets.
+func (config *Config) validate() []string {
+	var problems []string
+	negative := func(name string, value float64) {
+		if value < 0 {
+			problems = append(problems, fmt.Sprintf("%s must not be negative", name))
+		}
+	}
+	for _, option := range []struct {
+		name  string
+		value *int
+	}{{"maxopen", config.MaxOpen}, {"maxidle", config.MaxIdle}, {"storm", config.Storm}, {"batch", config.Batch}, {"retries", config.Retries}} {
+		if option.value != nil {
+			negative(option.name, float64(*option.value))
+		}
+	}
+	for _, option := range []struct {
+		name  string
+		value *float64
+	}{{"count", config.Count}, {"rate", config.Rate}, {"replayspeed", config.ReplaySpeed}, {"threshold", config.Threshold}} {
+		if option.value != nil {
+			negative(option.name, *option.value)
+		}
+	}
+	for _, option := range []struct {
+		name  string
+		value *duration
+	}{{"timeout", config.Timeout}, {"readtimeout", config.ReadTimeout}, {"writetimeout", config.WriteTimeout}, {"lagpoll", config.LagPoll},
+		{"connlifetime", config.ConnLifetime}, {"duration", config.Duration}, {"backoff", config.Backoff}, {"mdlpoll", config.MDLPoll}, {"interval", config.Interval}} {
+		if option.value != nil {
+			negative(option.name, float64(*option.value))
+		}
+	}
+
+	if config.Threads != nil && (*config.Threads < 1 || *config.Threads != math.Trunc(*config.Threads)) {
+		problems = append(problems, fmt.Sprintf("threads must be a whole number of at least 1, not %v", *config.Threads))
+	}
+	if config.OnError != nil && !validOnError(*config.OnError) {
+		problems = append(problems, fmt.Sprintf("onerror must be %q, %q or %q, not %q", onErrorAbort, onErrorContinue, onErrorRetry, *config.OnError))
+	}
+	if config.Bulk != nil {
+		switch strings.ToLower(*config.Bulk) {
+		case "", bulkInsert, bulkUpsert, bulkReplace, bulkInfile:
+		default:
+			problems = append(problems, fmt.Sprintf("bulk must be %q, %q, %q or %q, not %q", bulkInsert, bulkUpsert, bulkReplace, bulkInfile, *config.Bulk))
+		}
+	}
+	if config.ReplayFormat != nil {
+		switch *config.ReplayFormat {
+		case logFormatAuto, logFormatGeneral, logFormatSlow:
+		default:
+			problems = append(problems, fmt.Sprintf("replayformat must be %q, %q or %q, not %q", logFormatAuto, logFormatGeneral, logFormatSlow, *config.ReplayFormat))
+		}
+	}
+	return problems
+}
+
+// configLayer is one source of settings.
+type configLayer struct {
+	source string
+	config Config
+}
+
+// setting is the effective value of an option and the layer it came from.
+type setting struct {
+	name   string
+	value  string
+	source string
+}
+
+// commandLineFlags holds the flags given on the command line, which no
+// config layer may override.
+var commandLineFlags = make(map[string]bool)
+
+// effectiveConfig holds the merged settings after loadConfig.
+var effectiveConfig []setting
+
+// userConfigFile returns the path of the per-user config layer.
+func userConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(dir, "lomax", "lomax.json")
+}
+
+// searchDirs returns the directories a relative file name is looked up in,
+// after the working directory: subdir of the working directory and of the
+// directory of the lomax executable, followed by extra.
+func searchDirs(subdir string, extra ...string) []string {
+	dirs := []string{subdir}
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Join(filepath.Dir(exe), subdir))
+	}
+	return append(dirs, extra...)
+}
+
+// resolvePath returns the file name refers to.  Absolute paths are used as
+// they are, relative ones are looked up in the working directory and then in
+// dirs.
+func resolvePath(name string, dirs []string) (string, error) {
+	if strings.HasPrefix(name, "~/") {
+		name = filepath.Join(os.Getenv("HOME"), name[2:])
+	}
+	if filepath.IsAbs(name) {
+		if _, err := os.Stat(name); err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+	candidates := []string{name}
+	for _, dir := range dirs {
+		candidates = append(candidates, filepath.Join(dir, name))
+	}
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found in the working directory or in %s", name, strings.Join(dirs, ", "))
+}
+
+// configDirs are the directories --config files are looked up in.
+func configDirs() []string {
+	return searchDirs("lib", filepath.Dir(userConfigFile()), filepath.Dir(systemConfigFile))
+}
+
+// readConfigLayer reads a JSON config file.
+func readConfigLayer(path string) (configLayer, []string) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return configLayer{source: path}, []string{err.Error()}
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return configLayer{source: path}, []string{fmt.Sprintf("%s: %s", path, err.Error())}
+	}
+	layer, problems := newConfigLayer(path, values)
+	if password := layer.config.Password; password != nil && *password != "" {
+		if err := checkPrivate(path); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return layer, problems
+}
+
+// newConfigLayer decodes the JSON settings of a layer into its Config.
+// Every key must name an option and every value must be of the option's
+// type.  All problems of the layer are returned, not just the first one.
+func newConfigLayer(source string, values map[string]json.RawMessage) (configLayer, []string) {
+	layer := configLayer{source: source}
+	var problems []string
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fields := reflect.ValueOf(&layer.config).Elem()
+	for _, key := range keys {
+		if configMetadata[key] {
+			continue
+		}
+		index, ok := configFields[key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: unknown option %q", source, key))
+			continue
+		}
+		if string(values[key]) == "null" {
+			continue
+		}
+		field := fields.Field(index)
+		value := reflect.New(field.Type().Elem())
+		if err := json.Unmarshal(values[key], value.Interface()); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s must be %s, not %s", source, key, configKinds[field.Type().Elem()], values[key]))
+			continue
+		}
+		field.Set(value)
+	}
+	for _, problem := range layer.config.validate() {
+		problems = append(problems, fmt.Sprintf("%s: %s", source, problem))
+	}
+	return layer, problems
+}
+
+// envName returns the environment variable that sets a flag.
+func envName(name string) string {
+	return "LOMAX_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// envLayer returns the settings of the LOMAX_* environment variables.
+func envLayer() (configLayer, []string) {
+	layer := configLayer{source: "environment"}
+	var problems []string
+	names := make([]string, 0, len(configFields))
+	for name := range configFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if value, ok := os.LookupEnv(envName(name)); ok {
+			if err := layer.config.set(name, value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", envName(name), err.Error()))
+			}
+		}
+	}
+	for _, problem := range layer.config.validate() {
+		problems = append(problems, fmt.Sprintf("%s: %s", layer.source, problem))
+	}
+	return layer, problems
+}
+
+// recordCommandLine remembers which flags were given on the command line.
+// It must be called after flag.Parse and before any layer is applied.
+func recordCommandLine() {
+	flag.Visit(func(f *flag.Flag) {
+		commandLineFlags[f.Name] = true
+	})
+}
+
+// loadConfig merges the config layers and applies them to the flags.
+// fileLayer holds the settings of a workload or vector file, if any.  All
+// problems are reported at once.
+func loadConfig(fileLayer *configLayer) {
+	var layers []configLayer
+	var problems []string
+	addFile := func(path string, optional bool) {
+		if _, err := os.Stat(path); optional && os.IsNotExist(err) {
+			return
+		}
+		layer, layerProblems := readConfigLayer(path)
+		layers = append(layers, layer)
+		problems = append(problems, layerProblems...)
+	}
+
+	addFile(systemConfigFile, true)
+	addFile(userConfigFile(), true)
+
+	env, envProblems := envLayer()
+	problems = append(problems, envProblems...)
+	name := jsonConfig
+	if !commandLineFlags["config"] && env.config.ConfigFile != nil && *env.config.ConfigFile != "" {
+		name = *env.config.ConfigFile
+	}
+	if name != "" {
+		path, err := resolvePath(name, configDirs())
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("--config: %s", err.Error()))
+		} else {
+			addFile(path, false)
+		}
+	}
+	if fileLayer != nil {
+		layers = append(layers, *fileLayer)
+	}
+	layers = append(layers, env)
+
+	problems = append(problems, applyLayers(layers)...)
+	if len(problems) > 0 {
+		log.Error("Invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+}
+
+// applyLayers sets every flag that was not given on the command line to its
+// value in the last layer that has one, and records the effective settings.
+func applyLayers(layers []configLayer) []string {
+	merged := make(map[string]setting)
+	for _, layer := range layers {
+		for name, value := range layer.config.values() {
+			merged[name] = setting{name: name, value: value, source: layer.source}
+		}
+	}
+
+	var problems []string
+	effectiveConfig = nil
+	flag.VisitAll(func(f *flag.Flag) {
+		current := setting{name: f.Name, value: f.Value.String(), source: "default"}
+		if commandLineFlags[f.Name] {
+			current.source = "command line"
+		} else if s, ok := merged[f.Name]; ok {
+			if err := f.Value.Set(s.value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid value %q for %s: %s", s.source, s.value, f.Name, err.Error()))
+			} else {
+				current = s
+			}
+		}
+		effectiveConfig = append(effectiveConfig, current)
+	})
+	return problems
+}
+
+// configParse applies a config file found in the config search path on top
+// of the current options.
+func configParse(name string) {
+	path, err := resolvePath(name, configDirs())
+	if err != nil {
+		log.Error(fmt.Sprintf("File IO Error: %s\n", err.Error()))
+	}
+	layer, problems := readConfigLayer(path)
+	problems = append(problems, applyLayers([]configLayer{layer})...)
+	if len(problems) > 0 {
+		log.Error("Invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+}
+
+// isConfigShow reports whether lomax was run as "lomax config show".
+func isConfigShow(args []string) bool {
+	return len(args) >= 2 && args[0] == "config" && args[1] == "show"
+}
+
+// showConfig prints the effective configuration and where every setting
+// came from.  Passwords are masked, see maskSecret.
+func showConfig() {
+	settings := append([]setting(nil), effectiveConfig...)
+	sort.Slice(settings, func(i, j int) bool { return settings[i].name < settings[j].name })
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Option", "Value", "Source"})
+	for _, s := range settings {
+		// Settings no layer gave may have been filled in by
+		// resolveCredentials.
+		if value := flag.Lookup(s.name).Value.String(); value != s.value {
+			s.value, s.source = value, "credentials"
+		}
+		table.Append([]string{s.name, maskSecret(s.name, s.value), s.source})
+	}
+	table.Render()
+}
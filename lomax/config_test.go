@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// saveConfigFlags returns a function that restores the options changed by a
+// config test.
+func saveConfigFlags() func() {
+	host, port, threads, duration, onError, commandLine := hostNamePtr, portPtr, threadPtr, durationPtr, onErrorPtr, commandLineFlags
+	commandLineFlags = make(map[string]bool)
+	return func() {
+		hostNamePtr, portPtr, threadPtr, durationPtr, onErrorPtr, commandLineFlags = host, port, threads, duration, onError, commandLine
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lomax")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "site.json")
+	if err := ioutil.WriteFile(path, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		dirs []string
+		want string
+	}{
+		{path, nil, path},
+		{"site.json", []string{"nowhere", dir}, path},
+		{"openstack-generic-config.json", configDirs(), "lib/openstack-generic-config.json"},
+		{"new-hires.json", searchDirs("testvectors/workloads"), "testvectors/workloads/new-hires.json"},
+	}
+	for _, c := range cases {
+		got, err := resolvePath(c.name, c.dirs)
+		if err != nil || got != c.want {
+			t.Errorf("resolvePath(%q) = %q, %v, want %q", c.name, got, err, c.want)
+		}
+	}
+	if _, err := resolvePath("missing.json", []string{dir}); err == nil {
+		t.Errorf("resolvePath() of a missing file returned no error")
+	}
+}
+
+// jsonLayer decodes a config layer from JSON.
+func jsonLayer(t *testing.T, source string, text string) (configLayer, []string) {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(text), &values); err != nil {
+		t.Fatal(err)
+	}
+	return newConfigLayer(source, values)
+}
+
+func TestNewConfigLayer(t *testing.T) {
+	layer, problems := jsonLayer(t, "test", `{"name": "metadata", "hostname": "db1", "threads": 16, "prepared": false, "duration": "5m", "hostnme": "typo", "port": [3306]}`)
+	want := map[string]string{"hostname": "db1", "threads": "16", "prepared": "false", "duration": "5m0s"}
+	if values := layer.config.values(); !reflect.DeepEqual(values, want) {
+		t.Errorf("newConfigLayer() values = %v, want %v", values, want)
+	}
+	// Every problem is reported, not just the first one.
+	if len(problems) != 2 {
+		t.Errorf("newConfigLayer() problems = %q, want 2", problems)
+	}
+
+	_, problems = jsonLayer(t, "user", `{"threads": "many", "duration": "soon", "retries": 1.5, "rate": -1, "onerror": "sometimes", "bulk": "copy"}`)
+	if len(problems) != 6 || !strings.Contains(problems[0], "user: duration must be a duration") {
+		t.Errorf("newConfigLayer() of invalid values = %q", problems)
+	}
+}
+
+func TestConfigFields(t *testing.T) {
+	flag.VisitAll(func(f *flag.Flag) {
+		if _, ok := configFields[f.Name]; !ok && !strings.HasPrefix(f.Name, "test.") {
+			t.Errorf("option %s has no Config field", f.Name)
+		}
+	})
+	for name := range configFields {
+		if flag.Lookup(name) == nil {
+			t.Errorf("Config field %s has no option", name)
+		}
+	}
+}
+
+func TestApplyLayers(t *testing.T) {
+	defer saveConfigFlags()()
+	hostNamePtr, portPtr, threadPtr, durationPtr = "", "", 1, 0
+	commandLineFlags["port"] = true
+	portPtr = "3307"
+
+	system, _ := jsonLayer(t, "system", `{"hostname": "system", "threads": 4, "port": "3306"}`)
+	user, _ := jsonLayer(t, "user", `{"hostname": "user", "duration": "5m"}`)
+	env, _ := jsonLayer(t, "environment", `{"threads": 8, "onerror": "retry"}`)
+	problems := applyLayers([]configLayer{system, user, env})
+	if len(problems) != 0 {
+		t.Fatalf("applyLayers() problems = %q", problems)
+	}
+	if hostNamePtr != "user" || threadPtr != 8 || durationPtr != 5*time.Minute || onErrorPtr != onErrorRetry {
+		t.Errorf("applyLayers() set hostname %q, threads %v, duration %v, onerror %q", hostNamePtr, threadPtr, durationPtr, onErrorPtr)
+	}
+	if portPtr != "3307" {
+		t.Errorf("applyLayers() overrode the command line port with %q", portPtr)
+	}
+
+	sources := make(map[string]string)
+	for _, s := range effectiveConfig {
+		sources[s.name] = s.source
+	}
+	want := map[string]string{"hostname": "user", "threads": "environment", "port": "command line", "table": "default"}
+	for name, source := range want {
+		if sources[name] != source {
+			t.Errorf("%s came from %q, want %q", name, sources[name], source)
+		}
+	}
+}
+
+func TestEnvLayer(t *testing.T) {
+	os.Setenv("LOMAX_HOSTNAME", "envhost")
+	os.Setenv("LOMAX_THREADS", "many")
+	defer os.Unsetenv("LOMAX_HOSTNAME")
+	defer os.Unsetenv("LOMAX_THREADS")
+
+	layer, problems := envLayer()
+	if layer.config.Hostname == nil || *layer.config.Hostname != "envhost" {
+		t.Errorf("envLayer() hostname = %v, want envhost", layer.config.Hostname)
+	}
+	if len(problems) != 1 || !strings.Contains(problems[0], "LOMAX_THREADS: threads must be a number") {
+		t.Errorf("envLayer() problems = %q", problems)
+	}
+	if envName("defaults-file") != "LOMAX_DEFAULTS_FILE" {
+		t.Errorf("envName(defaults-file) = %q", envName("defaults-file"))
+	}
+}
+
+func TestFileConfigLayers(t *testing.T) {
+	workload := &Workload{DB: "employees", Threads: 4, duration: 30 * time.Second, source: "w.json"}
+	want := map[string]string{"db": "employees", "threads": "4", "duration": "30s"}
+	if layer := workload.configLayer(); !reflect.DeepEqual(layer.config.values(), want) {
+		t.Errorf("workload configLayer() = %v, want %v", layer.config.values(), want)
+	}
+
+	vector, err := parseVector([]byte(`{"config": {"threads": 8, "onerror": "retry"}, "testcases": [{"action": "SELECT", "test_table": "employees"}]}`))
+	if err != nil {
+		t.Fatalf("parseVector() returned error %v", err)
+	}
+	want = map[string]string{"threads": "8", "onerror": "retry"}
+	if layer := vector.configLayer(); !reflect.DeepEqual(layer.config.values(), want) {
+		t.Errorf("vector configLayer() = %v, want %v", layer.config.values(), want)
+	}
+	if _, err := parseVector([]byte(`{"config": {"thread": 8}, "testcases": [{"action": "SELECT", "test_table": "employees"}]}`)); err == nil {
+		t.Errorf("parseVector() accepted an unknown config option")
+	}
+}
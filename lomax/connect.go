@@ -0,0 +1,214 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/opendns/lemming/lib/log"
+)
+
+var maxOpenPtr, maxIdlePtr int
+var connLifetimePtr time.Duration
+var stormPtr int
+
+func init() {
+	flag.IntVar(&maxOpenPtr, "maxopen", 0, "Share one connection pool of at most this many connections between all threads. 0 gives every thread its own connection.")
+	flag.IntVar(&maxIdlePtr, "maxidle", 2, "Maximum number of idle connections kept in a connection pool.")
+	flag.DurationVar(&connLifetimePtr, "connlifetime", 0, "Close pooled connections after they have been open this long: e.g. 1m. 0 keeps them forever.")
+	flag.IntVar(&stormPtr, "storm", 0, "With --operation=CONNECT, open this many connections at once, hold them until all are open and close them again, --count times.")
+}
+
+// isConnect reports whether the connect benchmark was selected with
+// --operation=CONNECT.
+func isConnect() bool {
+	return strings.ToUpper(operationPtr) == "CONNECT"
+}
+
+// configurePool applies the connection pool options to db.
+func configurePool(db *sql.DB) {
+	db.SetMaxOpenConns(maxOpenPtr)
+	db.SetMaxIdleConns(maxIdlePtr)
+	db.SetConnMaxLifetime(connLifetimePtr)
+}
+
+// connect opens a new connection and forces the TCP and authentication
+// handshake, which sql.Open alone does not do.  It returns the connection
+// and the time the handshake took.
+func connect() (*sql.DB, time.Duration, error) {
+	start := time.Now()
+	db := initializeDB(USER, PASSWORD, hostNamePtr, portPtr, dbPtr)
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	err := db.Ping()
+	elapsed := time.Since(start)
+	if err != nil {
+		db.Close()
+		return nil, elapsed, err
+	}
+	return db, elapsed, nil
+}
+
+// threadStatus reads the server counters that show how connections are
+// served: a connection that did not create a thread reused one from the
+// thread cache.
+func threadStatus(db *sql.DB) map[string]int64 {
+	status := make(map[string]int64)
+	rows, err := db.Query("SHOW GLOBAL STATUS WHERE Variable_name IN ('Connections', 'Threads_created', 'Max_used_connections', 'Connection_errors_max_connections')")
+	if err != nil {
+		log.Warning(fmt.Sprintf("[%s]: %s", GetFunctionName(threadStatus), err.Error()))
+		return status
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err == nil {
+			status[name], _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return status
+}
+
+// printThreadCache prints how the connections opened between two
+// threadStatus readings were served by the server.
+func printThreadCache(before map[string]int64, after map[string]int64) {
+	connections := after["Connections"] - before["Connections"]
+	created := after["Threads_created"] - before["Threads_created"]
+	if connections <= 0 {
+		return
+	}
+	fmt.Println(fmt.Sprintf("Thread cache: %d of %d connections reused a cached thread (%.1f%%), %d threads created, %d max used connections, %d refused for max_connections",
+		connections-created, connections, 100*float64(connections-created)/float64(connections), created,
+		after["Max_used_connections"], after["Connection_errors_max_connections"]-before["Connection_errors_max_connections"]))
+}
+
+// runConnect benchmarks establishing connections.  Every thread
+// repeatedly connects and disconnects, or with --storm all connections of a
+// round are opened at once.
+func runConnect() {
+	fmt.Println(fmt.Sprintf("Running connect benchmark, please wait..."))
+
+	if logPrefix == "" {
+		log.Warning(fmt.Sprintf("[%s]: No --logprefix defined, log file will NOT be created", GetFunctionName(exportData)))
+	}
+
+	control, _, err := connect()
+	if err != nil {
+		log.Error("[%s]: %s", GetFunctionName(runConnect), err.Error())
+	}
+	defer control.Close()
+	before := threadStatus(control)
+
+	step := &WorkloadStep{Action: "CONNECT"}
+	forEachTransport(func(suffix string) {
+		pool := &workerPool{}
+		threads := int(threadPtr)
+		if stormPtr > 0 || threads < 1 {
+			threads = 1
+		}
+		for id := 0; id < threads; id++ {
+			pool.workers = append(pool.workers, newWorker(id, nil, 1))
+		}
+
+		name := fmt.Sprintf("main.connect[%d]%s", len(pool.workers), suffix)
+		sched := schedule{iterations: int(countPtr), duration: durationPtr, rate: ratePtr}
+		var memBefore, memAfter runtime.MemStats
+		runtime.ReadMemStats(&memBefore)
+		if stormPtr > 0 {
+			name = fmt.Sprintf("main.connectionStorm[%d]%s", stormPtr, suffix)
+		}
+		status := startStatusProbe(name, []target{{host: hostNamePtr, port: portPtr}})
+		reporter := startIntervalReporter(pool, intervalPtr, status)
+		var elapsed time.Duration
+		if stormPtr > 0 {
+			elapsed = pool.run(func(w *worker) {
+				w.loop(sched, 1, func(intended time.Time) {
+					w.storm(stormPtr)
+				})
+			})
+		} else {
+			elapsed = pool.run(func(w *worker) {
+				w.loop(sched, len(pool.workers), func(intended time.Time) {
+					w.connectAt(step, intended)
+				})
+			})
+		}
+		reporter.Stop()
+		status.finish()
+		runtime.ReadMemStats(&memAfter)
+
+		stats := pool.stats()
+		collectResult(name, elapsed, stats[0].iterations,
+			fmt.Sprintf("%d", memAfter.Mallocs-memBefore.Mallocs), fmt.Sprintf("%d", memAfter.TotalAlloc-memBefore.TotalAlloc), &stats[0])
+		printThroughput(stats[0].iterations, elapsed, sched.rate)
+	})
+	printData()
+	printThreadCache(before, threadStatus(control))
+}
+
+// connectAt opens and closes a single connection that was scheduled to be
+// opened at intended.
+func (w *worker) connectAt(step *WorkloadStep, intended time.Time) {
+	var failures [errorClasses]int
+	result := sample{}
+	attempt := 0
+	for ; ; attempt++ {
+		db, handshake, err := connect()
+		result.busy += handshake
+		if err == nil {
+			db.Close()
+			break
+		}
+		failures[classifyError(err)]++
+		if !w.retryError(step, err, attempt) {
+			result.failed = true
+			break
+		}
+	}
+	result.failures, result.retries = failures, attempt
+	result.latency = time.Since(intended)
+	w.record(0, result)
+}
+
+// storm opens connections connections at once, waits until every one of them
+// is established or has failed, and closes them again.  Every handshake is
+// recorded, failures are only counted: refused connections are the point of
+// a storm.
+func (w *worker) storm(connections int) {
+	var wg sync.WaitGroup
+	dbs := make([]*sql.DB, connections)
+	var failed int32
+	for i := range dbs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			db, handshake, err := connect()
+			result := sample{busy: handshake, latency: handshake}
+			if err != nil {
+				result.failed = true
+				result.failures[classifyError(err)]++
+				if atomic.AddInt32(&failed, 1) == 1 {
+					log.Warning(fmt.Sprintf("[%s]: %s", GetFunctionName((*worker).storm), err.Error()))
+				}
+			}
+			dbs[i] = db
+			w.record(0, result)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, db := range dbs {
+		if db != nil {
+			db.Close()
+		}
+	}
+	if failed > 0 {
+		log.Warning(fmt.Sprintf("[%s]: %d of %d connections failed", GetFunctionName((*worker).storm), failed, connections))
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigurePool(t *testing.T) {
+	defer func(maxOpen int, maxIdle int, lifetime time.Duration) {
+		maxOpenPtr, maxIdlePtr, connLifetimePtr = maxOpen, maxIdle, lifetime
+	}(maxOpenPtr, maxIdlePtr, connLifetimePtr)
+	maxOpenPtr, maxIdlePtr, connLifetimePtr = 8, 4, time.Minute
+
+	// sql.Open does not dial, so no server is needed.
+	db := initializeDB("user", "password", "127.0.0.1", "3306", "employees")
+	defer db.Close()
+	if open := db.Stats().MaxOpenConnections; open != 8 {
+		t.Errorf("MaxOpenConnections = %d, want 8", open)
+	}
+}
+
+func TestIsConnect(t *testing.T) {
+	defer func(operation string) { operationPtr = operation }(operationPtr)
+	for operation, want := range map[string]bool{"connect": true, "CONNECT": true, "SELECT": false, "": false} {
+		operationPtr = operation
+		if got := isConnect(); got != want {
+			t.Errorf("isConnect() with --operation=%q = %t, want %t", operation, got, want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opendns/lemming/lib/log"
+)
+
+var defaultsFilePtr, passwordFilePtr string
+
+// optionFiles are the option files read for the [client] group, in the
+// order they are read.  Later files override earlier ones.
+var optionFiles = []string{"/etc/my.cnf", "/etc/mysql/my.cnf", "~/.my.cnf"}
+
+// optionGroups are the option file groups lomax reads.
+var optionGroups = []string{"client", "lomax"}
+
+func init() {
+	flag.StringVar(&defaultsFilePtr, "defaults-file", "", "Read the [client] and [lomax] groups of only this option file instead of /etc/my.cnf, /etc/mysql/my.cnf and ~/.my.cnf.")
+	flag.StringVar(&passwordFilePtr, "passwordfile", "", "Read the MySQL password from this file. It must not be accessible by other users (chmod 600).")
+}
+
+// resolveCredentials fills in the connection options that no config layer
+// set.  They are looked up, in order, in the --passwordfile, the MYSQL_USER,
+// MYSQL_PWD, MYSQL_HOST, MYSQL_TCP_PORT and MYSQL_UNIX_PORT environment
+// variables, and the option files.  Only an option file whose password is
+// used must not be accessible by other users.
+func resolveCredentials() {
+	if PASSWORD != "" && passwordSetOnCommandLine() {
+		log.Warning("Using --password on the command line can be insecure, consider MYSQL_PWD, --passwordfile or an option file.")
+	}
+
+	if PASSWORD == "" && passwordFilePtr != "" {
+		password, err := readPasswordFile(passwordFilePtr)
+		if err != nil {
+			log.Error("[%s]: %s", GetFunctionName(resolveCredentials), err.Error())
+		}
+		PASSWORD = password
+	}
+
+	options := map[string]string{
+		"user":     os.Getenv("MYSQL_USER"),
+		"password": os.Getenv("MYSQL_PWD"),
+		"host":     os.Getenv("MYSQL_HOST"),
+		"port":     os.Getenv("MYSQL_TCP_PORT"),
+		"socket":   os.Getenv("MYSQL_UNIX_PORT"),
+	}
+	files := optionFiles
+	if defaultsFilePtr != "" {
+		files = []string{defaultsFilePtr}
+	}
+	fileOptions := make(map[string]string)
+	var passwordFile string
+	for _, file := range files {
+		values, err := readOptionFile(file)
+		if os.IsNotExist(err) && defaultsFilePtr == "" {
+			continue
+		}
+		if err != nil {
+			log.Error("[%s]: %s", GetFunctionName(resolveCredentials), err.Error())
+		}
+		for key, value := range values {
+			fileOptions[key] = value
+		}
+		if values["password"] != "" {
+			passwordFile = file
+		}
+	}
+	if PASSWORD == "" && options["password"] == "" && passwordFile != "" {
+		if err := checkPrivate(expandHome(passwordFile)); err != nil {
+			log.Error("[%s]: %s", GetFunctionName(resolveCredentials), err.Error())
+		}
+	}
+	for key, value := range fileOptions {
+		if options[key] == "" {
+			options[key] = value
+		}
+	}
+
+	fill := func(option *string, key string) {
+		if *option == "" {
+			*option = options[key]
+		}
+	}
+	fill(&USER, "user")
+	fill(&PASSWORD, "password")
+	fill(&hostNamePtr, "host")
+	fill(&portPtr, "port")
+	// As with the mysql client, the socket is only used without a host.
+	if hostNamePtr == "" {
+		fill(&socketPtr, "socket")
+	}
+}
+
+// passwordSetOnCommandLine reports whether --password was given.
+func passwordSetOnCommandLine() bool {
+	return commandLineFlags["password"]
+}
+
+// checkPrivate refuses a credential file that other users can access.
+func checkPrivate(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0007 != 0 {
+		return fmt.Errorf("%s holds credentials but is accessible by other users (mode %s), run: chmod 600 %s", path, info.Mode().Perm(), path)
+	}
+	return nil
+}
+
+// readPasswordFile returns the password stored in a secrets file.
+func readPasswordFile(path string) (string, error) {
+	if err := checkPrivate(path); err != nil {
+		return "", err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	password, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil && password == "" {
+		return "", fmt.Errorf("%s: no password found", path)
+	}
+	return strings.TrimRight(password, "\r\n"), nil
+}
+
+// expandHome expands a leading ~/ of path to the home directory.
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[2:])
+	}
+	return path
+}
+
+// readOptionFile returns the options of the optionGroups groups of a my.cnf
+// style option file.  The caller checks the permissions of a file whose
+// password it uses.
+func readOptionFile(path string) (map[string]string, error) {
+	path = expandHome(path)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	options, err := parseOptionFile(bufio.NewScanner(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return options, nil
+}
+
+// parseOptionFile parses the lines of an option file.  Dashes and
+// underscores in option names are equivalent, and !include directives are
+// ignored.
+func parseOptionFile(scanner *bufio.Scanner) (map[string]string, error) {
+	options := make(map[string]string)
+	inGroup := false
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case text == "" || text[0] == '#' || text[0] == ';' || text[0] == '!':
+			continue
+		case text[0] == '[':
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("line %d: unterminated group %q", line, text)
+			}
+			group := strings.ToLower(strings.TrimSpace(text[1 : len(text)-1]))
+			inGroup = false
+			for _, name := range optionGroups {
+				inGroup = inGroup || group == name
+			}
+			continue
+		case !inGroup:
+			continue
+		}
+
+		parts := strings.SplitN(text, "=", 2)
+		key := strings.Replace(strings.ToLower(strings.TrimSpace(parts[0])), "_", "-", -1)
+		value := ""
+		if len(parts) == 2 {
+			value = strings.TrimSpace(parts[1])
+			if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+				value = value[1 : len(value)-1]
+			} else if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		options[key] = value
+	}
+	return options, scanner.Err()
+}
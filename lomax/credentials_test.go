@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOptionFile(t *testing.T) {
+	input := `# MySQL client options
+[mysqld]
+password = server-side
+
+[client]
+user=bench
+password = "s3cret # not a comment"
+host = db1 # the primary
+socket_file_is_ignored
+!includedir /etc/mysql/conf.d/
+
+[lomax]
+port=3307
+`
+	options, err := parseOptionFile(bufio.NewScanner(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"user": "bench", "password": "s3cret # not a comment", "host": "db1", "port": "3307", "socket-file-is-ignored": ""}
+	if !reflect.DeepEqual(options, want) {
+		t.Errorf("parseOptionFile() = %v, want %v", options, want)
+	}
+
+	if _, err := parseOptionFile(bufio.NewScanner(strings.NewReader("[client\nuser=x\n"))); err == nil {
+		t.Errorf("parseOptionFile() accepted an unterminated group")
+	}
+}
+
+func TestCredentialFilePermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lomax")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secret := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(secret, []byte("s3cret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readPasswordFile(secret); err == nil {
+		t.Errorf("readPasswordFile() accepted a world-readable file")
+	}
+	os.Chmod(secret, 0600)
+	if password, err := readPasswordFile(secret); err != nil || password != "s3cret" {
+		t.Errorf("readPasswordFile() = %q, %v, want s3cret", password, err)
+	}
+
+	cnf := filepath.Join(dir, "my.cnf")
+	if err := ioutil.WriteFile(cnf, []byte("[client]\nhost=db1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if options, err := readOptionFile(cnf); err != nil || options["host"] != "db1" {
+		t.Errorf("readOptionFile() without a password = %v, %v, want it to be readable", options, err)
+	}
+}
+
+func TestResolveCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lomax")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cnf := filepath.Join(dir, "my.cnf")
+	ioutil.WriteFile(cnf, []byte("[client]\nuser=fromfile\npassword=filepw\nhost=db1\nsocket=/tmp/mysql.sock\n"), 0600)
+
+	defer func(user, password, host, port, socket, defaults string) {
+		USER, PASSWORD, hostNamePtr, portPtr, socketPtr, defaultsFilePtr = user, password, host, port, socket, defaults
+	}(USER, PASSWORD, hostNamePtr, portPtr, socketPtr, defaultsFilePtr)
+	defer os.Setenv("MYSQL_PWD", os.Getenv("MYSQL_PWD"))
+	defer os.Setenv("MYSQL_USER", os.Getenv("MYSQL_USER"))
+
+	USER, PASSWORD, hostNamePtr, portPtr, socketPtr, defaultsFilePtr = "", "", "", "3306", "", cnf
+	os.Setenv("MYSQL_USER", "")
+	os.Setenv("MYSQL_PWD", "envpw")
+	resolveCredentials()
+	if USER != "fromfile" || PASSWORD != "envpw" || hostNamePtr != "db1" || portPtr != "3306" || socketPtr != "" {
+		t.Errorf("resolveCredentials() = user %q, password %q, host %q, port %q, socket %q", USER, PASSWORD, hostNamePtr, portPtr, socketPtr)
+	}
+
+	// The password of a world-readable option file is refused only when it
+	// is used.
+	os.Chmod(cnf, 0644)
+	USER, PASSWORD, hostNamePtr = "", "", ""
+	resolveCredentials()
+	if USER != "fromfile" || PASSWORD != "envpw" {
+		t.Errorf("resolveCredentials() with an unused world-readable password = user %q, password %q", USER, PASSWORD)
+	}
+	os.Setenv("MYSQL_PWD", "")
+	USER, PASSWORD, hostNamePtr = "", "", ""
+	defer func() {
+		if recover() == nil {
+			t.Errorf("resolveCredentials() used the password of a world-readable option file")
+		}
+	}()
+	resolveCredentials()
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/opendns/lemming/lib/log"
+)
+
+// Dataset is the data a workload runs on.  lomax prepare creates and loads
+// it, lomax cleanup resets it, so that every run starts from the same data:
+//
+//	"dataset": {
+//	  "tables": [
+//	    {"name": "accounts", "ddl": "CREATE TABLE accounts (id INT PRIMARY KEY, balance DECIMAL(12,2))", "rows": 1000000,
+//	     "generators": {"id": {"type": "sequence", "start": 1, "step": 1}}}
+//	  ],
+//	  "load": "infile",
+//	  "cleanup": "restore"
+//	}
+type Dataset struct {
+	Tables []DatasetTable `json:"tables"`
+	// Load is how the rows are loaded: "insert" (the default), with
+	// multi-row INSERTs, or "infile", with LOAD DATA LOCAL INFILE.
+	Load string `json:"load"`
+	// Batch is the number of rows per statement, 1000 by default.
+	Batch int `json:"batch"`
+	// Cleanup is "drop" (the default), which drops the tables, "truncate",
+	// which empties them, or "restore", which restores the snapshot taken
+	// at the end of prepare.
+	Cleanup string `json:"cleanup"`
+	// Snapshot lists tables that are not part of the dataset, but that the
+	// workload alters and restore should restore.
+	Snapshot []string `json:"snapshot"`
+}
+
+// DatasetTable is a table of a dataset.  DDL creates the table, replacing
+// any table of the same name; without it the table must already exist.
+// Rows generated rows are loaded into it, see newRowGenerator.
+type DatasetTable struct {
+	Name       string                   `json:"name"`
+	DDL        string                   `json:"ddl"`
+	Rows       int64                    `json:"rows"`
+	Generators map[string]GeneratorSpec `json:"generators"`
+}
+
+const (
+	cleanupDrop     = "drop"
+	cleanupTruncate = "truncate"
+	cleanupRestore  = "restore"
+
+	phasePrepare = "prepare"
+	phaseRun     = "run"
+	phaseCleanup = "cleanup"
+)
+
+// datasetSession turns foreign key checks off on the connections of
+// prepare and cleanup, so that tables can be dropped and filled in any
+// order.
+var datasetSession = map[string]string{"foreign_key_checks": "0"}
+
+// snapshotSuffix names the copy prepare keeps of every table for restore.
+const snapshotSuffix = "_lomax_snapshot"
+
+// validate checks a dataset and fills in its defaults.
+func (dataset *Dataset) validate() error {
+	dataset.Load = strings.ToLower(dataset.Load)
+	if dataset.Load == "" {
+		dataset.Load = bulkInsert
+	}
+	if dataset.Load != bulkInsert && dataset.Load != bulkInfile {
+		return fmt.Errorf("invalid dataset load %q, must be %q or %q", dataset.Load, bulkInsert, bulkInfile)
+	}
+	dataset.Cleanup = strings.ToLower(dataset.Cleanup)
+	if dataset.Cleanup == "" {
+		dataset.Cleanup = cleanupDrop
+	}
+	switch dataset.Cleanup {
+	case cleanupDrop, cleanupTruncate, cleanupRestore:
+	default:
+		return fmt.Errorf("invalid dataset cleanup %q, must be %q, %q or %q", dataset.Cleanup, cleanupDrop, cleanupTruncate, cleanupRestore)
+	}
+	if dataset.Batch < 0 {
+		return fmt.Errorf("invalid dataset batch %d", dataset.Batch)
+	}
+	if dataset.Batch == 0 {
+		dataset.Batch = defaultBatch
+	}
+	if len(dataset.Tables) == 0 && len(dataset.Snapshot) == 0 {
+		return fmt.Errorf("dataset has no tables")
+	}
+	if len(dataset.Snapshot) > 0 && dataset.Cleanup != cleanupRestore {
+		return fmt.Errorf("dataset snapshot needs \"cleanup\": %q", cleanupRestore)
+	}
+
+	seen := make(map[string]bool)
+	for i, table := range dataset.Tables {
+		if table.Name == "" {
+			return fmt.Errorf("dataset table %d has no name", i+1)
+		}
+		if seen[strings.ToLower(table.Name)] {
+			return fmt.Errorf("dataset table %s is listed twice", table.Name)
+		}
+		seen[strings.ToLower(table.Name)] = true
+		if table.Rows < 0 {
+			return fmt.Errorf("dataset table %s: invalid rows %d", table.Name, table.Rows)
+		}
+		if table.DDL == "" && table.Rows == 0 && dataset.Cleanup != cleanupRestore {
+			return fmt.Errorf("dataset table %s needs a ddl or rows", table.Name)
+		}
+	}
+	for _, name := range dataset.Snapshot {
+		if seen[strings.ToLower(name)] {
+			return fmt.Errorf("dataset table %s is listed twice", name)
+		}
+		seen[strings.ToLower(name)] = true
+	}
+	return nil
+}
+
+// tableNames returns every table of the dataset, the snapshot tables
+// included.
+func (dataset *Dataset) tableNames() []string {
+	var names []string
+	for _, table := range dataset.Tables {
+		names = append(names, table.Name)
+	}
+	return append(names, dataset.Snapshot...)
+}
+
+// isPhases returns the phases args starts with: lomax prepare run cleanup
+// --workload=...
+func isPhases(args []string) []string {
+	var phases []string
+	for _, arg := range args {
+		if arg != phasePrepare && arg != phaseRun && arg != phaseCleanup {
+			break
+		}
+		phases = append(phases, arg)
+	}
+	return phases
+}
+
+// phaseProblems returns why the phases can not run.
+func phaseProblems(phases []string, workload *Workload) []string {
+	for _, phase := range phases {
+		if phase == phaseRun {
+			continue
+		}
+		if workload == nil || workload.Dataset == nil {
+			return []string{fmt.Sprintf("lomax %s needs a --workload with a dataset.", phase)}
+		}
+	}
+	return nil
+}
+
+// prepareDataset creates and loads the tables of the workload's dataset and,
+// for restore, snapshots them.
+func prepareDataset(workload *Workload) {
+	dataset := workload.Dataset
+	fmt.Println(fmt.Sprintf("Preparing the dataset of workload %q (%d tables), please wait...", workload.Name, len(dataset.Tables)))
+	db := datasetDB()
+	defer db.Close()
+
+	for _, table := range dataset.Tables {
+		if table.DDL != "" {
+			if err := execAll(db, fmt.Sprintf("DROP TABLE IF EXISTS `%s`", table.Name), table.DDL); err != nil {
+				log.Error("[%s]: table %s: %s", GetFunctionName(prepareDataset), table.Name, err.Error())
+			}
+		}
+		if table.Rows > 0 {
+			loadTable(db, table, dataset)
+		}
+	}
+
+	if dataset.Cleanup == cleanupRestore {
+		start := time.Now()
+		for _, name := range dataset.tableNames() {
+			snapshot := name + snapshotSuffix
+			if err := execAll(db, fmt.Sprintf("DROP TABLE IF EXISTS `%s`", snapshot), fmt.Sprintf("CREATE TABLE `%s` LIKE `%s`", snapshot, name),
+				fmt.Sprintf("INSERT INTO `%s` SELECT * FROM `%s`", snapshot, name)); err != nil {
+				log.Error("[%s]: snapshot of %s: %s", GetFunctionName(prepareDataset), name, err.Error())
+			}
+		}
+		collectResult("snapshot "+workload.Name, time.Since(start), len(dataset.tableNames()), "-", "-", nil)
+	}
+	printData()
+}
+
+// loadTable loads the generated rows of table with a bulk step, spread over
+// --threads workers.
+func loadTable(db *sql.DB, table DatasetTable, dataset *Dataset) {
+	step := WorkloadStep{Action: "INSERT", Table: table.Name, Random: true, Generators: table.Generators, Bulk: dataset.Load, Batch: dataset.Batch}
+	step.prepare(db)
+	pool := newTargetPool(int(threadPtr), 1, dbPtr, []target{{host: hostNamePtr, port: portPtr}}, datasetSession)
+	defer pool.close()
+	pool.prepare([]WorkloadStep{step})
+	workers := int64(len(pool.workers))
+
+	elapsed := pool.run(func(w *worker) {
+		share := table.Rows / workers
+		if int64(w.id) < table.Rows%workers {
+			share++
+		}
+		for share > 0 {
+			n := int64(step.Batch)
+			if share < n {
+				n = share
+			}
+			share -= n
+
+			start := time.Now()
+			result, err := w.execBulk(nil, 0, &step, int(n))
+			if err != nil {
+				log.Error("[%s]: table %s: %s", GetFunctionName(loadTable), table.Name, err.Error())
+			}
+			result.busy = time.Since(start)
+			result.latency = result.busy
+			w.record(0, result)
+		}
+	})
+
+	stats := pool.stats()
+	name := fmt.Sprintf("prepare %s", table.Name)
+	collectResult(name, elapsed, stats[0].iterations, "-", "-", &stats[0])
+	collectBulk(name, &step, elapsed, &stats[0])
+	fmt.Println(fmt.Sprintf("[%s]: Loaded %d rows into %s in %s: %.0f rows/sec, %.0f bytes/sec", GetFunctionName(prepareDataset), stats[0].rows, table.Name, elapsed,
+		float64(stats[0].rows)/elapsed.Seconds(), float64(stats[0].bytes)/elapsed.Seconds()))
+}
+
+// cleanupDataset drops, truncates or restores the tables of the workload's
+// dataset.  Restore keeps the snapshots, so that the next run can be
+// restored again.
+func cleanupDataset(workload *Workload) {
+	dataset := workload.Dataset
+	fmt.Println(fmt.Sprintf("Cleaning up the dataset of workload %q (%s), please wait...", workload.Name, dataset.Cleanup))
+	db := datasetDB()
+	defer db.Close()
+
+	start := time.Now()
+	var statements []string
+	for _, name := range dataset.tableNames() {
+		statements = append(statements, cleanupStatements(dataset.Cleanup, name)...)
+	}
+	if err := execAll(db, statements...); err != nil {
+		log.Error("[%s]: %s", GetFunctionName(cleanupDataset), err.Error())
+	}
+	collectResult(fmt.Sprintf("cleanup %s", workload.Name), time.Since(start), len(statements), "-", "-", nil)
+	printData()
+}
+
+// cleanupStatements returns the statements that clean up table.
+func cleanupStatements(cleanup string, table string) []string {
+	switch cleanup {
+	case cleanupTruncate:
+		return []string{fmt.Sprintf("TRUNCATE TABLE `%s`", table)}
+	case cleanupRestore:
+		return []string{fmt.Sprintf("TRUNCATE TABLE `%s`", table), fmt.Sprintf("INSERT INTO `%s` SELECT * FROM `%s%s`", table, table, snapshotSuffix)}
+	}
+	return []string{fmt.Sprintf("DROP TABLE IF EXISTS `%s`", table)}
+}
+
+// datasetDB opens a single connection to the database, with the session of
+// datasetSession.
+func datasetDB() *sql.DB {
+	db := initializeSessionDB(datasetSession, USER, PASSWORD, hostNamePtr, portPtr, dbPtr)
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	return db
+}
+
+// execAll executes statements in order, stopping at the first error.
+func execAll(db *sql.DB, statements ...string) error {
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			return fmt.Errorf("%s: %s", statement, err.Error())
+		}
+	}
+	return nil
+}
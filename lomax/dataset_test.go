@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDatasetValidate(t *testing.T) {
+	cases := []struct {
+		dataset   Dataset
+		wantError bool
+	}{
+		{Dataset{Tables: []DatasetTable{{Name: "t", DDL: "CREATE TABLE t (id INT)", Rows: 10}}}, false},
+		{Dataset{Tables: []DatasetTable{{Name: "t", Rows: 10}}, Load: "INFILE", Cleanup: "truncate"}, false},
+		{Dataset{Snapshot: []string{"employees"}, Cleanup: "restore"}, false},
+		{Dataset{}, true},
+		{Dataset{Snapshot: []string{"employees"}}, true},
+		{Dataset{Tables: []DatasetTable{{Name: "t", Rows: 10}}, Load: "copy"}, true},
+		{Dataset{Tables: []DatasetTable{{Name: "t", Rows: 10}}, Cleanup: "delete"}, true},
+		{Dataset{Tables: []DatasetTable{{Name: "t", Rows: -1}}}, true},
+		{Dataset{Tables: []DatasetTable{{Name: "t"}}}, true},
+		{Dataset{Tables: []DatasetTable{{Rows: 10}}}, true},
+		{Dataset{Tables: []DatasetTable{{Name: "t", Rows: 10}}, Snapshot: []string{"T"}, Cleanup: "restore"}, true},
+	}
+	for _, c := range cases {
+		dataset := c.dataset
+		err := dataset.validate()
+		if (err != nil) != c.wantError {
+			t.Errorf("validate(%+v) returned error %v, want error %t", c.dataset, err, c.wantError)
+			continue
+		}
+		if err == nil && (dataset.Batch != 1000 || dataset.Load == "" || dataset.Cleanup == "") {
+			t.Errorf("validate(%+v) = %+v, want defaults", c.dataset, dataset)
+		}
+	}
+}
+
+func TestIsPhases(t *testing.T) {
+	cases := map[string][]string{
+		"":                                     nil,
+		"compare a b":                          nil,
+		"prepare --workload=x":                 {"prepare"},
+		"prepare run cleanup --workload=x run": {"prepare", "run", "cleanup"},
+	}
+	for input, want := range cases {
+		if got := isPhases(strings.Fields(input)); !reflect.DeepEqual(got, want) {
+			t.Errorf("isPhases(%q) = %q, want %q", input, got, want)
+		}
+	}
+
+	if problems := phaseProblems([]string{phaseRun}, nil); len(problems) != 0 {
+		t.Errorf("phaseProblems(run) = %q, want none", problems)
+	}
+	if problems := phaseProblems([]string{phasePrepare}, &Workload{}); len(problems) != 1 {
+		t.Errorf("phaseProblems(prepare) without a dataset = %q, want a problem", problems)
+	}
+}
+
+func TestCleanupStatements(t *testing.T) {
+	if got := cleanupStatements(cleanupDrop, "t"); !reflect.DeepEqual(got, []string{"DROP TABLE IF EXISTS `t`"}) {
+		t.Errorf("cleanupStatements(drop) = %q", got)
+	}
+	want := []string{"TRUNCATE TABLE `t`", "INSERT INTO `t` SELECT * FROM `t_lomax_snapshot`"}
+	if got := cleanupStatements(cleanupRestore, "t"); !reflect.DeepEqual(got, want) {
+		t.Errorf("cleanupStatements(restore) = %q, want %q", got, want)
+	}
+}
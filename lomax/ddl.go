@@ -0,0 +1,378 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opendns/lemming/lib/log"
+)
+
+const actionDDL = "DDL"
+
+// mdlWaitState is the processlist state of a session waiting for a metadata
+// lock held by, or queued behind, a DDL.
+const mdlWaitState = "Waiting for table metadata lock"
+
+var mdlPollPtr time.Duration
+
+// ddlBuffer holds the DDL report, one row per DDL step.
+var ddlBuffer [][]string
+var ddlHeader = []string{"DDL Step", "Duration", "Error", "Queries/sec Before", "Queries/sec During", "Queries/sec After", "Throughput Change",
+	"P99 Before", "P99 During", "P99 After", "Errors During", "MDL Waiters", "MDL Wait", "DDL MDL Wait"}
+
+func init() {
+	flag.DurationVar(&mdlPollPtr, "mdlpoll", 100*time.Millisecond, "Poll the processlist for metadata lock waits this often while a DDL step of a workload runs.")
+}
+
+// schemaChange is the run of a DDL step in the middle of a workload.
+type schemaChange struct {
+	step    *WorkloadStep
+	started bool
+	start   time.Time
+	end     time.Time
+	err     error
+	// first and last are the totals of the other steps when the DDL
+	// started and ended.
+	first stepStats
+	last  stepStats
+	// windows holds the queries of the other steps that completed before,
+	// during and after the DDL.
+	windows [3]ddlWindow
+	// waiters is the most sessions seen waiting for a metadata lock at
+	// once, waited the time they waited in total and ddlWaited the time
+	// the DDL itself waited.
+	waiters   int
+	waited    time.Duration
+	ddlWaited time.Duration
+}
+
+// ddlWindow is the period before, during or after a DDL.
+type ddlWindow struct {
+	length time.Duration
+	stats  stepStats
+}
+
+// schemaChanges runs the DDL steps of a workload while its other steps run
+// on pool.
+type schemaChanges struct {
+	pool    *workerPool
+	target  target
+	begin   time.Time
+	changes []*schemaChange
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// validateDDL checks a DDL step and builds its statement.
+func (step *WorkloadStep) validateDDL(label string) error {
+	if step.Query != "" || step.Action != "" || step.Table != "" || step.Random || len(step.Params) > 0 || step.Bulk != "" {
+		return fmt.Errorf("%s: a DDL step can only hold the ddl, algorithm, lock and after", label)
+	}
+	fields := strings.Fields(strings.ToUpper(step.DDL))
+	if len(fields) == 0 {
+		return fmt.Errorf("%s: empty ddl", label)
+	}
+	switch fields[0] {
+	case "ALTER", "CREATE", "DROP", "RENAME", "OPTIMIZE", "TRUNCATE":
+	default:
+		return fmt.Errorf("%s: %q is not a DDL statement", label, step.DDL)
+	}
+	if step.Weight > 0 {
+		return fmt.Errorf("%s: DDL steps run once and take no weight", label)
+	}
+	if step.After != "" {
+		after, err := time.ParseDuration(step.After)
+		if err != nil || after < 0 {
+			return fmt.Errorf("%s: invalid after %q", label, step.After)
+		}
+		step.after = after
+	}
+
+	var options []string
+	if step.Algorithm != "" {
+		switch strings.ToUpper(step.Algorithm) {
+		case "DEFAULT", "INSTANT", "INPLACE", "COPY":
+		default:
+			return fmt.Errorf("%s: invalid algorithm %q", label, step.Algorithm)
+		}
+		options = append(options, "ALGORITHM="+strings.ToUpper(step.Algorithm))
+	}
+	if step.Lock != "" {
+		switch strings.ToUpper(step.Lock) {
+		case "DEFAULT", "NONE", "SHARED", "EXCLUSIVE":
+		default:
+			return fmt.Errorf("%s: invalid lock %q", label, step.Lock)
+		}
+		options = append(options, "LOCK="+strings.ToUpper(step.Lock))
+	}
+
+	statement := strings.TrimSuffix(strings.TrimSpace(step.DDL), ";")
+	if len(options) > 0 {
+		// ALTER TABLE takes the options as clauses, CREATE and DROP INDEX
+		// after the statement.
+		switch {
+		case len(fields) > 1 && fields[0] == "ALTER" && fields[1] == "TABLE":
+			statement += ", " + strings.Join(options, ", ")
+		case isIndexDDL(fields):
+			statement += " " + strings.Join(options, " ")
+		default:
+			return fmt.Errorf("%s: algorithm and lock only apply to ALTER TABLE, CREATE INDEX and DROP INDEX", label)
+		}
+	}
+	step.query = statement
+	step.Action = actionDDL
+	return nil
+}
+
+// isIndexDDL reports whether the words of a statement make a CREATE INDEX,
+// with its UNIQUE, FULLTEXT or SPATIAL variants, or a DROP INDEX.
+func isIndexDDL(fields []string) bool {
+	switch {
+	case len(fields) > 1 && (fields[0] == "CREATE" || fields[0] == "DROP") && fields[1] == "INDEX":
+		return true
+	case len(fields) > 2 && fields[0] == "CREATE" && fields[2] == "INDEX":
+		return true
+	}
+	return false
+}
+
+// startSchemaChanges schedules the DDL steps of workload on a connection of
+// their own to t.  It returns nil if the workload has none.
+func startSchemaChanges(workload *Workload, pool *workerPool, t target) *schemaChanges {
+	changes := &schemaChanges{pool: pool, target: t, begin: time.Now(), stop: make(chan struct{})}
+	for i := range workload.Steps {
+		if workload.Steps[i].DDL != "" {
+			change := &schemaChange{step: &workload.Steps[i]}
+			workload.Steps[i].change = change
+			changes.changes = append(changes.changes, change)
+		}
+	}
+	if len(changes.changes) == 0 {
+		return nil
+	}
+	for _, change := range changes.changes {
+		changes.wg.Add(1)
+		go changes.run(change)
+	}
+	return changes
+}
+
+// run runs a DDL once its delay has passed, unless the workload ended
+// before.
+func (changes *schemaChanges) run(change *schemaChange) {
+	defer changes.wg.Done()
+	select {
+	case <-time.After(change.step.after):
+	case <-changes.stop:
+		return
+	}
+	change.started = true
+
+	db := initializeDB(USER, PASSWORD, changes.target.host, changes.target.port, dbPtr)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	var id int64
+	if change.err = db.QueryRow("SELECT CONNECTION_ID()").Scan(&id); change.err != nil {
+		change.start = time.Now()
+		change.end = change.start
+		return
+	}
+
+	fmt.Println(fmt.Sprintf("[%s]: Running DDL %s", GetFunctionName(runWorkload), change.step.query))
+	stop, done := make(chan struct{}), make(chan struct{})
+	go change.pollMDL(changes.target, id, mdlPollPtr, stop, done)
+	change.first = totalStats(changes.pool)
+	change.start = time.Now()
+	_, change.err = db.Exec(change.step.query)
+	change.end = time.Now()
+	change.last = totalStats(changes.pool)
+	close(stop)
+	<-done
+}
+
+// pollMDL counts the sessions of t waiting for a metadata lock every
+// interval until stop is closed.  id is the connection of the DDL.
+func (change *schemaChange) pollMDL(t target, id int64, interval time.Duration, stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	if interval <= 0 {
+		return
+	}
+	db := initializeDB(USER, PASSWORD, t.host, t.port, "")
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		waiting, ddlWaiting, err := mdlWaits(db, id)
+		if err != nil {
+			log.Warning(fmt.Sprintf("[%s]: %s, not polling metadata lock waits", GetFunctionName(mdlWaits), err.Error()))
+			return
+		}
+		if waiting > change.waiters {
+			change.waiters = waiting
+		}
+		change.waited += time.Duration(waiting) * interval
+		if ddlWaiting {
+			change.ddlWaited += interval
+		}
+	}
+}
+
+// mdlWaits returns the number of sessions other than the DDL's connection id
+// that wait for a metadata lock, and whether the DDL waits for one.  Seeing
+// the sessions of other users needs the PROCESS privilege.
+func mdlWaits(db *sql.DB, id int64) (int, bool, error) {
+	rows, err := db.Query("SELECT ID FROM information_schema.PROCESSLIST WHERE STATE = ?", mdlWaitState)
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	waiting, ddlWaiting := 0, false
+	for rows.Next() {
+		var session int64
+		if err := rows.Scan(&session); err != nil {
+			return 0, false, err
+		}
+		if session == id {
+			ddlWaiting = true
+		} else {
+			waiting++
+		}
+	}
+	return waiting, ddlWaiting, rows.Err()
+}
+
+// totalStats returns the statistics of all steps of pool added together.
+func totalStats(pool *workerPool) stepStats {
+	total := newStepStats(1)[0]
+	for _, stats := range pool.stats() {
+		total.add(&stats)
+	}
+	return total
+}
+
+// wait waits for the running DDL steps once the other steps are done, skips
+// those that have not started yet, and splits the run into the windows before,
+// during and after every DDL.  It is safe to call on nil.
+func (changes *schemaChanges) wait() {
+	if changes == nil {
+		return
+	}
+	end := time.Now()
+	close(changes.stop)
+	changes.wg.Wait()
+
+	for _, change := range changes.changes {
+		if change.first.latency == nil {
+			continue
+		}
+		before, during, after := &change.windows[0], &change.windows[1], &change.windows[2]
+		// subtract changes the histogram it subtracts from, so last is
+		// subtracted from the final totals before first is subtracted
+		// from last.
+		after.stats = totalStats(changes.pool)
+		after.stats.subtract(&change.last)
+		if change.end.Before(end) {
+			after.length = end.Sub(change.end)
+		}
+		during.stats = change.last
+		during.stats.subtract(&change.first)
+		during.length = change.end.Sub(change.start)
+		if change.end.After(end) {
+			during.length = end.Sub(change.start)
+		}
+		before.length, before.stats = change.start.Sub(changes.begin), change.first
+	}
+}
+
+// collectSchemaChange adds the result row and the DDL row of a DDL step.
+func collectSchemaChange(name string, change *schemaChange) {
+	if !change.started {
+		log.Warning(fmt.Sprintf("[%s]: %s did not start before the workload ended", GetFunctionName(runWorkload), name))
+		ddlBuffer = append(ddlBuffer, append([]string{name, "-", "not started"}, dashes(len(ddlHeader)-3)...))
+		resultDoc.DDL = append(resultDoc.DDL, ddlRecord{Name: name, Statement: change.step.query, Error: "not started"})
+		return
+	}
+
+	duration := change.end.Sub(change.start)
+	stats := newStepStats(1)[0]
+	result := sample{busy: duration, latency: duration}
+	errText := "-"
+	if change.err != nil {
+		result.failed = true
+		result.failures[classifyError(change.err)]++
+		errText = change.err.Error()
+	}
+	stats.record(result)
+	collectResult(name, duration, 1, "-", "-", &stats)
+
+	dip := "-"
+	if pct, ok := change.throughputChange(); ok {
+		dip = fmt.Sprintf("%+.1f%%", pct)
+	}
+	row := []string{name, fmt.Sprintf("%s", duration), errText}
+	for _, window := range change.windows {
+		row = append(row, window.rateColumn())
+	}
+	row = append(row, dip)
+	for _, window := range change.windows {
+		row = append(row, window.p99Column())
+	}
+	row = append(row, fmt.Sprintf("%d", change.windows[1].stats.errors), fmt.Sprintf("%d", change.waiters), fmt.Sprintf("%s", change.waited), fmt.Sprintf("%s", change.ddlWaited))
+	ddlBuffer = append(ddlBuffer, row)
+	resultDoc.DDL = append(resultDoc.DDL, newDDLRecord(name, change))
+}
+
+// throughputChange returns the change in percent of the queries per second
+// during the DDL from before it, if both were measured.
+func (change *schemaChange) throughputChange() (float64, bool) {
+	before, during := &change.windows[0], &change.windows[1]
+	if before.rate() <= 0 || during.length <= 0 {
+		return 0, false
+	}
+	return 100 * (during.rate() - before.rate()) / before.rate(), true
+}
+
+// rate returns the queries per second of the window.
+func (window *ddlWindow) rate() float64 {
+	if window.length <= 0 {
+		return 0
+	}
+	return float64(window.stats.iterations) / window.length.Seconds()
+}
+
+func (window *ddlWindow) rateColumn() string {
+	if window.length <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", window.rate())
+}
+
+func (window *ddlWindow) p99Column() string {
+	if window.length <= 0 || window.stats.latency.count == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%s", window.stats.latency.percentile(99))
+}
+
+// dashes returns n empty report columns.
+func dashes(n int) []string {
+	columns := make([]string, n)
+	for i := range columns {
+		columns[i] = "-"
+	}
+	return columns
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateDDL(t *testing.T) {
+	cases := []struct {
+		step      WorkloadStep
+		statement string
+		wantError bool
+	}{
+		{WorkloadStep{DDL: "ALTER TABLE salaries ADD INDEX (salary)", Algorithm: "inplace", Lock: "none", After: "30s"}, "ALTER TABLE salaries ADD INDEX (salary), ALGORITHM=INPLACE, LOCK=NONE", false},
+		{WorkloadStep{DDL: "alter table employees add column age int;", Algorithm: "INSTANT"}, "alter table employees add column age int, ALGORITHM=INSTANT", false},
+		{WorkloadStep{DDL: "CREATE UNIQUE INDEX i ON t (a)", Lock: "shared"}, "CREATE UNIQUE INDEX i ON t (a) LOCK=SHARED", false},
+		{WorkloadStep{DDL: "DROP INDEX i ON t", Algorithm: "inplace"}, "DROP INDEX i ON t ALGORITHM=INPLACE", false},
+		{WorkloadStep{DDL: "OPTIMIZE TABLE t"}, "OPTIMIZE TABLE t", false},
+		{WorkloadStep{DDL: "DROP TABLE t", Lock: "none"}, "", true},
+		{WorkloadStep{DDL: "SELECT 1"}, "", true},
+		{WorkloadStep{DDL: "ALTER TABLE t ADD c INT", Algorithm: "fast"}, "", true},
+		{WorkloadStep{DDL: "ALTER TABLE t ADD c INT", Lock: "all"}, "", true},
+		{WorkloadStep{DDL: "ALTER TABLE t ADD c INT", After: "soon"}, "", true},
+		{WorkloadStep{DDL: "ALTER TABLE t ADD c INT", Table: "t"}, "", true},
+		{WorkloadStep{DDL: "ALTER TABLE t ADD c INT", Weight: 1}, "", true},
+	}
+	for _, c := range cases {
+		step := c.step
+		err := step.validateDDL("step 1")
+		if (err != nil) != c.wantError {
+			t.Errorf("validateDDL(%+v) returned error %v, want error %t", c.step, err, c.wantError)
+			continue
+		}
+		if err == nil && (step.query != c.statement || step.Action != actionDDL) {
+			t.Errorf("validateDDL(%+v) = %q, want %q", c.step, step.query, c.statement)
+		}
+	}
+
+	if _, err := parseWorkload([]byte(`{"name": "ddl", "steps": [{"ddl": "ALTER TABLE t ADD c INT"}]}`)); err == nil {
+		t.Errorf("parseWorkload() of a workload of only DDL steps succeeded")
+	}
+	workload, err := parseWorkload([]byte(`{"name": "ddl", "mode": "weighted", "count": 10, "steps": [{"action": "SELECT", "table": "t", "weight": 1}, {"ddl": "ALTER TABLE t ADD c INT", "after": "1s"}]}`))
+	if err != nil {
+		t.Fatalf("parseWorkload() of a weighted workload with a DDL step returned %s", err.Error())
+	}
+	if step := workload.Steps[1]; step.after != time.Second || step.label(1) != "[2] ALTER TABLE t" {
+		t.Errorf("DDL step = %+v, labeled %q", step, step.label(1))
+	}
+}
+
+func TestSchemaChangeWindows(t *testing.T) {
+	w := newWorker(0, nil, 1)
+	pool := &workerPool{workers: []*worker{w}}
+	begin := time.Now().Add(-10 * time.Second)
+	change := &schemaChange{step: &WorkloadStep{query: "ALTER TABLE t ADD c INT"}, started: true, start: begin.Add(4 * time.Second)}
+	changes := &schemaChanges{pool: pool, begin: begin, changes: []*schemaChange{change}, stop: make(chan struct{})}
+
+	for i := 0; i < 40; i++ {
+		w.record(0, sample{latency: time.Millisecond})
+	}
+	change.first = totalStats(pool)
+	for i := 0; i < 4; i++ {
+		w.record(0, sample{latency: 100 * time.Millisecond})
+	}
+	change.end = begin.Add(6 * time.Second)
+	change.last = totalStats(pool)
+	for i := 0; i < 20; i++ {
+		w.record(0, sample{latency: 2 * time.Millisecond})
+	}
+	changes.wait()
+
+	before, during, after := change.windows[0], change.windows[1], change.windows[2]
+	if before.stats.iterations != 40 || during.stats.iterations != 4 || after.stats.iterations != 20 {
+		t.Fatalf("window queries = %d, %d, %d, want 40, 4 and 20", before.stats.iterations, during.stats.iterations, after.stats.iterations)
+	}
+	if during.length != 2*time.Second || before.length != 4*time.Second || after.length < 4*time.Second {
+		t.Errorf("window lengths = %s, %s, %s", before.length, during.length, after.length)
+	}
+	if p99 := during.stats.latency.percentile(99); p99 < 99*time.Millisecond || p99 > 101*time.Millisecond {
+		t.Errorf("p99 during the DDL = %s, want 100ms", p99)
+	}
+	if pct, ok := change.throughputChange(); !ok || pct != -80 {
+		t.Errorf("throughputChange() = %v, %t, want -80%%", pct, ok)
+	}
+
+	ddlBuffer = nil
+	defer func() { ddlBuffer = nil }()
+	collectSchemaChange("[2] ALTER TABLE t", &schemaChange{step: change.step})
+	if len(ddlBuffer) != 1 || ddlBuffer[0][2] != "not started" || len(ddlBuffer[0]) != len(ddlHeader) {
+		t.Errorf("report of a DDL that did not start = %q", ddlBuffer)
+	}
+}
+
+func TestIsIndexDDL(t *testing.T) {
+	for statement, want := range map[string]bool{
+		"CREATE INDEX i ON t (a)":          true,
+		"CREATE FULLTEXT INDEX i ON t (a)": true,
+		"DROP INDEX i ON t":                true,
+		"CREATE TABLE index_log (a INT)":   false,
+		"DROP TABLE t":                     false,
+	} {
+		if got := isIndexDDL(strings.Fields(statement)); got != want {
+			t.Errorf("isIndexDDL(%q) = %t, want %t", statement, got, want)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var dsnPtr, socketPtr string
+var tlsPtr, tlsCAPtr, tlsCertPtr, tlsKeyPtr string
+var charsetPtr, collationPtr, sessionPtr string
+var timeoutPtr, readTimeoutPtr, writeTimeoutPtr time.Duration
+var transportsPtr string
+
+// transport is the transport of the current run, see forEachTransport.  It
+// is empty when the configured connection is used as it is.
+var transport string
+
+// The transports --transports can compare.
+const (
+	transportSocket = "socket"
+	transportTCP    = "tcp"
+	transportTLS    = "tls"
+)
+
+// tlsConfigName is the name lomax registers its custom TLS configuration
+// under with the driver.
+const tlsConfigName = "lomax"
+
+var registerTLSOnce sync.Once
+
+func init() {
+	flag.StringVar(&dsnPtr, "dsn", "", "Full go-sql-driver DSN, e.g. user:pass@unix(/tmp/mysql.sock)/db?tls=skip-verify. --user, --password, --hostname, --port, --db and the other connection options override its fields.")
+	flag.StringVar(&socketPtr, "socket", "", "Connect through this unix socket instead of TCP.")
+	flag.StringVar(&tlsPtr, "tls", "", "TLS mode: false, true (verify the server certificate) or skip-verify.")
+	flag.StringVar(&tlsCAPtr, "tlsca", "", "PEM file with the CA certificates to verify the server certificate against.")
+	flag.StringVar(&tlsCertPtr, "tlscert", "", "PEM file with the client certificate.")
+	flag.StringVar(&tlsKeyPtr, "tlskey", "", "PEM file with the client certificate's key.")
+	flag.StringVar(&charsetPtr, "charset", "", "Connection character set, e.g. utf8mb4.")
+	flag.StringVar(&collationPtr, "collation", "", "Connection collation, e.g. utf8mb4_general_ci.")
+	flag.DurationVar(&timeoutPtr, "timeout", 0, "Dial timeout, e.g. 5s.")
+	flag.DurationVar(&readTimeoutPtr, "readtimeout", 0, "I/O read timeout, e.g. 30s.")
+	flag.DurationVar(&writeTimeoutPtr, "writetimeout", 0, "I/O write timeout, e.g. 30s.")
+	flag.StringVar(&sessionPtr, "session", "", "Session variables to set on every connection: e.g. \"sql_mode='STRICT_ALL_TABLES',innodb_lock_wait_timeout=5\".")
+	flag.StringVar(&transportsPtr, "transports", "", "Run the benchmark once per transport to compare them: any of socket, tcp and tls, e.g. socket,tcp,tls.")
+}
+
+// buildDSN returns the DSN for connecting as user to db on host:port.  Empty
+// arguments keep the corresponding part of --dsn.
+func buildDSN(user string, password string, host string, port string, db string) (string, error) {
+	base := dsnPtr
+	if base == "" {
+		base = "/"
+	}
+	cfg, err := mysql.ParseDSN(base)
+	if err != nil {
+		return "", err
+	}
+
+	if user != "" {
+		cfg.User = user
+	}
+	if password != "" {
+		cfg.Passwd = password
+	}
+	if db != "" {
+		cfg.DBName = db
+	}
+	if host != "" {
+		cfg.Net, cfg.Addr = "tcp", tcpAddress(host, port)
+	}
+	if socketPtr != "" {
+		cfg.Net, cfg.Addr = "unix", socketPtr
+	}
+
+	if tlsPtr != "" {
+		cfg.TLSConfig = tlsPtr
+	}
+	if tlsCAPtr != "" || tlsCertPtr != "" {
+		if err := registerTLS(); err != nil {
+			return "", err
+		}
+		cfg.TLSConfig = tlsConfigName
+	}
+	if cfg.TLSConfig == "false" {
+		cfg.TLSConfig = ""
+	}
+
+	switch transport {
+	case transportSocket:
+		if socketPtr == "" {
+			return "", fmt.Errorf("the socket transport needs --socket")
+		}
+		cfg.Net, cfg.Addr, cfg.TLSConfig = "unix", socketPtr, ""
+	case transportTCP, transportTLS:
+		if cfg.Net != "tcp" {
+			if host == "" {
+				host, port = hostNamePtr, portPtr
+			}
+			if host == "" {
+				return "", fmt.Errorf("the %s transport needs --hostname", transport)
+			}
+			cfg.Net, cfg.Addr = "tcp", tcpAddress(host, port)
+		}
+		if transport == transportTCP {
+			cfg.TLSConfig = ""
+		} else if cfg.TLSConfig == "" {
+			cfg.TLSConfig = "skip-verify"
+		}
+	}
+
+	if collationPtr != "" {
+		cfg.Collation = collationPtr
+	}
+	if timeoutPtr > 0 {
+		cfg.Timeout = timeoutPtr
+	}
+	if readTimeoutPtr > 0 {
+		cfg.ReadTimeout = readTimeoutPtr
+	}
+	if writeTimeoutPtr > 0 {
+		cfg.WriteTimeout = writeTimeoutPtr
+	}
+	params, err := sessionParams()
+	if err != nil {
+		return "", err
+	}
+	for name, value := range params {
+		if cfg.Params == nil {
+			cfg.Params = make(map[string]string)
+		}
+		cfg.Params[name] = value
+	}
+	// Without prepared statements the driver interpolates the arguments
+	// itself and sends a single text protocol query.
+	cfg.InterpolateParams = cfg.InterpolateParams || !preparedPtr
+	return cfg.FormatDSN(), nil
+}
+
+// sessionDSN returns dsn with the session variables of session set on every
+// connection.
+func sessionDSN(dsn string, session map[string]string) (string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Params == nil {
+		cfg.Params = make(map[string]string)
+	}
+	for name, value := range session {
+		cfg.Params[name] = value
+	}
+	return cfg.FormatDSN(), nil
+}
+
+// tcpAddress returns the address of host:port, using the default MySQL port
+// if port is empty.
+func tcpAddress(host string, port string) string {
+	if port == "" {
+		port = "3306"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// sessionParams returns the DSN parameters for --charset and --session.  The
+// driver sets every parameter it does not know as a session variable.
+func sessionParams() (map[string]string, error) {
+	params := make(map[string]string)
+	if charsetPtr != "" {
+		params["charset"] = charsetPtr
+	}
+	for _, assignment := range splitSession(sessionPtr) {
+		parts := strings.SplitN(assignment, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid session variable %q, want name=value", assignment)
+		}
+		params[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return params, nil
+}
+
+// splitSession splits a list of session variable assignments at the commas
+// that are not quoted.
+func splitSession(session string) []string {
+	var assignments []string
+	var quote rune
+	start := 0
+	for i, c := range session {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ',':
+			assignments = append(assignments, session[start:i])
+			start = i + 1
+		}
+	}
+	if strings.TrimSpace(session[start:]) != "" {
+		assignments = append(assignments, session[start:])
+	}
+	return assignments
+}
+
+// registerTLS registers the TLS configuration built from --tlsca, --tlscert
+// and --tlskey with the driver.
+func registerTLS() error {
+	var err error
+	registerTLSOnce.Do(func() {
+		config := &tls.Config{InsecureSkipVerify: tlsPtr == "skip-verify"}
+		if tlsCAPtr != "" {
+			var pem []byte
+			if pem, err = ioutil.ReadFile(tlsCAPtr); err != nil {
+				return
+			}
+			config.RootCAs = x509.NewCertPool()
+			if !config.RootCAs.AppendCertsFromPEM(pem) {
+				err = fmt.Errorf("%s: no certificates found", tlsCAPtr)
+				return
+			}
+		}
+		if tlsCertPtr != "" {
+			var cert tls.Certificate
+			if cert, err = tls.LoadX509KeyPair(tlsCertPtr, tlsKeyPtr); err != nil {
+				return
+			}
+			config.Certificates = []tls.Certificate{cert}
+		}
+		err = mysql.RegisterTLSConfig(tlsConfigName, config)
+	})
+	return err
+}
+
+// forEachTransport calls run once for every transport listed in
+// --transports, or once with the configured connection if none are.  suffix
+// labels the results of a run.
+func forEachTransport(run func(suffix string)) {
+	if transportsPtr == "" {
+		run("")
+		return
+	}
+	for _, name := range strings.Split(transportsPtr, ",") {
+		transport = strings.ToLower(strings.TrimSpace(name))
+		fmt.Println(fmt.Sprintf("[%s]: Running over %s", GetFunctionName(forEachTransport), transport))
+		run(fmt.Sprintf(" (%s)", transport))
+	}
+	transport = ""
+}
+
+// validateConnection returns the problems of the connection options.
+func validateConnection() []string {
+	var problems []string
+	if dsnPtr != "" {
+		if _, err := mysql.ParseDSN(dsnPtr); err != nil {
+			problems = append(problems, fmt.Sprintf("Please specify a valid --dsn: %s", err.Error()))
+		}
+	}
+	switch tlsPtr {
+	case "", "false", "true", "skip-verify":
+	default:
+		problems = append(problems, "Please specify --tls=false, true or skip-verify.")
+	}
+	if (tlsCertPtr == "") != (tlsKeyPtr == "") {
+		problems = append(problems, "Please specify both --tlscert and --tlskey.")
+	}
+	if _, err := sessionParams(); err != nil {
+		problems = append(problems, fmt.Sprintf("Please specify valid --session variables: %s", err.Error()))
+	}
+	if transportsPtr != "" {
+		for _, name := range strings.Split(transportsPtr, ",") {
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case transportSocket:
+				if socketPtr == "" {
+					problems = append(problems, "Please specify a --socket to compare the socket transport.")
+				}
+			case transportTCP, transportTLS:
+			default:
+				problems = append(problems, "Please specify --transports from socket, tcp and tls.")
+			}
+		}
+	}
+	return problems
+}
+
+// maskSecret returns the value of an option as it may be shown or exported:
+// the password masked, and the password of a DSN left out.  A DSN that does
+// not parse is masked as a whole.
+func maskSecret(name string, value string) string {
+	switch {
+	case value == "":
+		return value
+	case name == "password":
+		return "********"
+	case name == "dsn":
+		cfg, err := mysql.ParseDSN(value)
+		if err != nil {
+			return "********"
+		}
+		cfg.Passwd = ""
+		return cfg.FormatDSN()
+	}
+	return value
+}
+
+// hasAddress reports whether the server's address is given by other means
+// than --hostname and --port.
+func hasAddress() bool {
+	return dsnPtr != "" || socketPtr != "" || targetsPtr != ""
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// saveConnectionFlags returns a function that restores the connection
+// options changed by a test.
+func saveConnectionFlags() func() {
+	dsn, socket, tlsMode, session, charset, timeout, prepared := dsnPtr, socketPtr, tlsPtr, sessionPtr, charsetPtr, timeoutPtr, preparedPtr
+	return func() {
+		dsnPtr, socketPtr, tlsPtr, sessionPtr, charsetPtr, timeoutPtr, preparedPtr = dsn, socket, tlsMode, session, charset, timeout, prepared
+		transport = ""
+	}
+}
+
+func TestBuildDSN(t *testing.T) {
+	defer saveConnectionFlags()()
+	preparedPtr = true
+
+	parse := func(user string, password string, host string, port string, db string) *mysql.Config {
+		dsn, err := buildDSN(user, password, host, port, db)
+		if err != nil {
+			t.Fatalf("buildDSN() returned error %v", err)
+		}
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			t.Fatalf("buildDSN() built invalid DSN %q: %v", dsn, err)
+		}
+		return cfg
+	}
+
+	cfg := parse("lomax", "secret", "db1", "3307", "employees")
+	if cfg.User != "lomax" || cfg.Passwd != "secret" || cfg.Net != "tcp" || cfg.Addr != "db1:3307" || cfg.DBName != "employees" || cfg.TLSConfig != "" {
+		t.Errorf("plain TCP config = %+v", cfg)
+	}
+
+	dsnPtr = "admin:pw@unix(/var/run/mysqld/mysqld.sock)/employees?tls=skip-verify&timeout=3s"
+	cfg = parse("", "", "", "", "")
+	if cfg.User != "admin" || cfg.Net != "unix" || cfg.Addr != "/var/run/mysqld/mysqld.sock" || cfg.TLSConfig != "skip-verify" || cfg.Timeout != 3*time.Second {
+		t.Errorf("--dsn config = %+v", cfg)
+	}
+	cfg = parse("", "", "", "", "titles")
+	if cfg.DBName != "titles" || cfg.User != "admin" {
+		t.Errorf("--dsn with explicit db = %+v, want db titles and the DSN's user", cfg)
+	}
+
+	dsnPtr, socketPtr, tlsPtr = "", "/tmp/mysql.sock", "false"
+	sessionPtr, charsetPtr, timeoutPtr = "sql_mode='STRICT_ALL_TABLES,NO_ZERO_DATE', innodb_lock_wait_timeout=5", "utf8mb4", time.Second
+	cfg = parse("lomax", "", "db1", "", "employees")
+	want := map[string]string{"sql_mode": "'STRICT_ALL_TABLES,NO_ZERO_DATE'", "innodb_lock_wait_timeout": "5", "charset": "utf8mb4"}
+	if cfg.Net != "unix" || cfg.Addr != "/tmp/mysql.sock" || !reflect.DeepEqual(cfg.Params, want) || cfg.Timeout != time.Second {
+		t.Errorf("socket config = %+v, params %v", cfg, cfg.Params)
+	}
+
+	transport = transportTLS
+	if cfg = parse("lomax", "", "db1", "", "employees"); cfg.Net != "tcp" || cfg.Addr != "db1:3306" || cfg.TLSConfig != "skip-verify" {
+		t.Errorf("tls transport config = %+v", cfg)
+	}
+	transport = transportSocket
+	if cfg = parse("lomax", "", "db1", "", "employees"); cfg.Net != "unix" || cfg.TLSConfig != "" {
+		t.Errorf("socket transport config = %+v", cfg)
+	}
+
+	transport, sessionPtr = "", "no_value"
+	if _, err := buildDSN("lomax", "", "db1", "", "employees"); err == nil {
+		t.Errorf("buildDSN() accepted an invalid --session")
+	}
+}
+
+func TestSessionDSN(t *testing.T) {
+	dsn, err := sessionDSN("lomax@tcp(db1:3306)/employees?sql_mode=ANSI", datasetSession)
+	if err != nil {
+		t.Fatalf("sessionDSN() returned error %v", err)
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil || cfg.Params["foreign_key_checks"] != "0" || cfg.Params["sql_mode"] != "ANSI" || cfg.Addr != "db1:3306" {
+		t.Errorf("sessionDSN() = %q, %v", dsn, err)
+	}
+}
+
+func TestSplitSession(t *testing.T) {
+	got := splitSession(`a=1,b='x,y',c="z"`)
+	want := []string{"a=1", "b='x,y'", `c="z"`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitSession() = %q, want %q", got, want)
+	}
+	if got := splitSession(""); len(got) != 0 {
+		t.Errorf("splitSession(\"\") = %q, want nothing", got)
+	}
+}
+
+func TestForEachTransport(t *testing.T) {
+	defer saveConnectionFlags()()
+	defer func(transports string) { transportsPtr = transports }(transportsPtr)
+
+	var runs []string
+	transportsPtr = "socket, TCP,tls"
+	forEachTransport(func(suffix string) { runs = append(runs, transport+suffix) })
+	want := []string{"socket (socket)", "tcp (tcp)", "tls (tls)"}
+	if !reflect.DeepEqual(runs, want) || transport != "" {
+		t.Errorf("forEachTransport() ran %q, want %q", runs, want)
+	}
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"database/sql"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/opendns/lemming/lib/log"
+)
+
+// stepStats accumulates the executions of a single workload step.
+type stepStats struct {
+	iterations int
+	// errors counts the executions that failed.
+	errors int
+	// rows and bytes count what the executions read from result sets.
+	rows  int64
+	bytes int64
+	// busy is the time spent executing the step, summed over all
+	// executions.
+	busy time.Duration
+	// latency records the duration of every single execution.
+	latency *histogram
+	// failures counts the errors of every class, including those of
+	// executions that succeeded when they were retried.
+	failures [errorClasses]int
+	retries  int
+	// commits and rollbacks count how the executions of a transaction step
+	// ended.
+	commits   int
+	rollbacks int
+}
+
+func newStepStats(steps int) []stepStats {
+	stats := make([]stepStats, steps)
+	for i := range stats {
+		stats[i].latency = newHistogram()
+	}
+	return stats
+}
+
+// add merges the executions recorded in other into s.
+func (s *stepStats) add(other *stepStats) {
+	s.iterations += other.iterations
+	s.errors += other.errors
+	s.rows += other.rows
+	s.bytes += other.bytes
+	s.busy += other.busy
+	s.latency.merge(other.latency)
+	for i, count := range other.failures {
+		s.failures[i] += count
+	}
+	s.retries += other.retries
+	s.commits += other.commits
+	s.rollbacks += other.rollbacks
+}
+
+// subtract removes the executions recorded in other, which must have been
+// recorded in s as well, from s.
+func (s *stepStats) subtract(other *stepStats) {
+	s.iterations -= other.iterations
+	s.errors -= other.errors
+	s.rows -= other.rows
+	s.bytes -= other.bytes
+	s.busy -= other.busy
+	s.latency.subtract(other.latency)
+	for i, count := range other.failures {
+		s.failures[i] -= count
+	}
+	s.retries -= other.retries
+	s.commits -= other.commits
+	s.rollbacks -= other.rollbacks
+}
+
+// clear drops all recorded executions, keeping the histogram allocated.
+func (s *stepStats) clear() {
+	s.iterations = 0
+	s.errors = 0
+	s.rows = 0
+	s.bytes = 0
+	s.busy = 0
+	s.latency.reset()
+	s.failures = [errorClasses]int{}
+	s.retries = 0
+	s.commits = 0
+	s.rollbacks = 0
+}
+
+// worker holds the state owned by a single benchmark goroutine: its own
+// connection, its own scan buffers and its own random source.
+type worker struct {
+	id int
+	db *sql.DB
+	// roles holds the worker's connection to a target of every role, see
+	// newTargetPool.
+	roles map[string]*sql.DB
+	rng   *rand.Rand
+	rows  rowBuffer
+	args  []interface{}
+	// prepared is whether the worker uses server-side prepared statements,
+	// --prepared unless the worker's statements can not be prepared.
+	prepared bool
+	// stmts holds the statement of every step, prepared on the worker's
+	// connection when prepared statements are in use.
+	stmts []*sql.Stmt
+	// lastErr is the most recent error of an operation of the worker.
+	lastErr error
+
+	// mu guards the statistics, which the interval reporter reads while
+	// the worker is running.
+	mu sync.Mutex
+	// steps holds the statistics of the whole run, interval those since
+	// the interval reporter last collected them.
+	steps    []stepStats
+	interval []stepStats
+}
+
+// workerPool runs benchmark phases on a fixed set of workers.  Connections
+// are established before the first phase starts, so dialing MySQL is never
+// part of the measured time.
+type workerPool struct {
+	workers []*worker
+	// dbs holds the connection pools opened for the workers.
+	dbs []*sql.DB
+}
+
+// newWorker returns a worker for the given connection pool.  Unless
+// --maxopen is set, nothing but the worker itself may use db.
+func newWorker(id int, db *sql.DB, steps int) *worker {
+	return &worker{
+		id:       id,
+		db:       db,
+		prepared: preparedPtr,
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano() + int64(id))),
+		stmts:    make([]*sql.Stmt, steps),
+		steps:    newStepStats(steps),
+		interval: newStepStats(steps),
+	}
+}
+
+// newWorkerPool opens one dedicated connection to dbName per worker, or a
+// single connection pool shared by all workers if --maxopen is set.  steps is
+// the number of workload steps the workers keep statistics for.
+func newWorkerPool(workers int, steps int, dbName string) *workerPool {
+	return newTargetPool(workers, steps, dbName, []target{{host: hostNamePtr, port: portPtr}}, nil)
+}
+
+// newTargetPool is newWorkerPool for a list of targets.  The first target is
+// every worker's default connection.  Every worker also connects to one
+// target of every role, spreading the workers evenly over the targets that
+// share a role.  Every connection sets the session variables of session on
+// top of those of --session.
+func newTargetPool(workers int, steps int, dbName string, targets []target, session map[string]string) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	pool := &workerPool{}
+	shared := make(map[string]*sql.DB)
+	open := func(id int, t target) *sql.DB {
+		if db := shared[t.name()]; db != nil {
+			return db
+		}
+		db := initializeSessionDB(session, USER, PASSWORD, t.host, t.port, dbName)
+		if maxOpenPtr > 0 {
+			shared[t.name()] = db
+		} else {
+			db.SetMaxOpenConns(1)
+			db.SetMaxIdleConns(1)
+		}
+		if err := db.Ping(); err != nil {
+			log.Error("[%s]: worker %s: %s", GetFunctionName(newTargetPool), strconv.Itoa(id), err.Error())
+		}
+		pool.dbs = append(pool.dbs, db)
+		return db
+	}
+
+	byRole := roleTargets(targets)
+	for id := 0; id < workers; id++ {
+		conns := make(map[string]*sql.DB)
+		connect := func(t target) *sql.DB {
+			if conns[t.name()] == nil {
+				conns[t.name()] = open(id, t)
+			}
+			return conns[t.name()]
+		}
+		w := newWorker(id, connect(targets[0]), steps)
+		for role, roleTargets := range byRole {
+			if w.roles == nil {
+				w.roles = make(map[string]*sql.DB)
+			}
+			w.roles[role] = connect(roleTargets[id%len(roleTargets)])
+		}
+		pool.workers = append(pool.workers, w)
+	}
+	return pool
+}
+
+// run executes task once on every worker.  All workers are released at the
+// same time once each of them is ready, and run returns the wall clock time
+// between that start and the last worker finishing.
+func (pool *workerPool) run(task func(w *worker)) time.Duration {
+	var ready, done sync.WaitGroup
+	start := make(chan struct{})
+
+	for _, w := range pool.workers {
+		ready.Add(1)
+		done.Add(1)
+		go func(w *worker) {
+			defer done.Done()
+			ready.Done()
+			<-start
+			task(w)
+		}(w)
+	}
+
+	ready.Wait()
+	begin := time.Now()
+	close(start)
+	done.Wait()
+	return time.Since(begin)
+}
+
+// stats returns the step statistics of all workers added together.
+func (pool *workerPool) stats() []stepStats {
+	total := newStepStats(len(pool.workers[0].steps))
+	for _, w := range pool.workers {
+		w.mu.Lock()
+		for i := range w.steps {
+			total[i].add(&w.steps[i])
+		}
+		w.mu.Unlock()
+	}
+	return total
+}
+
+// collectInterval swaps the interval statistics of every worker for the
+// cleared ones in spare, and returns the collected ones in spare's place.
+func (pool *workerPool) collectInterval(spare [][]stepStats) [][]stepStats {
+	for i, w := range pool.workers {
+		w.mu.Lock()
+		w.interval, spare[i] = spare[i], w.interval
+		w.mu.Unlock()
+	}
+	return spare
+}
+
+// reset clears the step statistics of all workers.
+func (pool *workerPool) reset() {
+	for _, w := range pool.workers {
+		w.mu.Lock()
+		for i := range w.steps {
+			w.steps[i].clear()
+			w.interval[i].clear()
+		}
+		w.mu.Unlock()
+	}
+}
+
+// prepare prepares the statements of all steps on the connection of every
+// worker that uses prepared statements, so that preparing is not part of
+// the measured time.
+func (pool *workerPool) prepare(steps []WorkloadStep) {
+	for _, w := range pool.workers {
+		if !w.prepared {
+			continue
+		}
+		for i := range steps {
+			if txn := steps[i].Transaction; txn != nil {
+				for j := range txn.Statements {
+					w.prepare(txn.Statements[j].slot, &txn.Statements[j])
+				}
+				continue
+			}
+			w.prepare(i, &steps[i])
+		}
+	}
+}
+
+func (pool *workerPool) close() {
+	for _, w := range pool.workers {
+		w.close()
+	}
+	for _, db := range pool.dbs {
+		db.Close()
+	}
+}
+
+// prepare prepares the statement of a step ahead of the run.  Unless
+// --onerror=abort, a statement that fails to prepare is left for the run to
+// retry and account for.
+func (w *worker) prepare(index int, step *WorkloadStep) {
+	// LOAD DATA can not be prepared, and DDL steps run on a connection of
+	// their own.
+	if step.Bulk == bulkInfile || step.DDL != "" {
+		return
+	}
+	if _, err := w.statement(index, step); err != nil && onErrorPtr == onErrorAbort {
+		log.Warning(step.query)
+		log.Error(err.Error())
+	}
+}
+
+// statement returns the prepared statement of a step, preparing it on first
+// use.
+func (w *worker) statement(index int, step *WorkloadStep) (*sql.Stmt, error) {
+	if w.stmts[index] == nil {
+		stmt, err := w.dbFor(step).Prepare(step.query)
+		if err != nil {
+			return nil, err
+		}
+		w.stmts[index] = stmt
+	}
+	return w.stmts[index], nil
+}
+
+// statementIn returns the prepared statement of a step for use within tx,
+// or outside of any transaction if tx is nil.  A transaction holds the
+// worker's only connection, so a statement that is not prepared yet, because
+// preparing it ahead of the run failed, is prepared on the transaction itself
+// and only lasts as long as it.
+func (w *worker) statementIn(tx *sql.Tx, index int, step *WorkloadStep) (*sql.Stmt, error) {
+	if tx == nil {
+		return w.statement(index, step)
+	}
+	if stmt := w.stmts[index]; stmt != nil {
+		return tx.Stmt(stmt), nil
+	}
+	return tx.Prepare(step.query)
+}
+
+// dbFor returns the connection the worker runs a step on: its connection to
+// the step's role, or its default one.
+func (w *worker) dbFor(step *WorkloadStep) *sql.DB {
+	if db := w.roles[step.Role]; step.Role != "" && db != nil {
+		return db
+	}
+	return w.db
+}
+
+// close releases the worker's prepared statements.
+func (w *worker) close() {
+	for i, stmt := range w.stmts {
+		if stmt != nil {
+			stmt.Close()
+			w.stmts[i] = nil
+		}
+	}
+}
+
+// schedule describes how many operations a worker issues, and how fast.
+type schedule struct {
+	// iterations is the number of operations per worker.  It is ignored
+	// when a duration is set.
+	iterations int
+	// duration is the wall clock time to keep issuing operations for.
+	duration time.Duration
+	// rate is the target number of operations per second over all
+	// workers.  Zero runs closed-loop, as fast as the server responds.
+	rate float64
+}
+
+// loop calls op until the schedule is exhausted.  op gets the time the
+// operation was meant to start.  With a rate, operations are issued
+// open-loop on a fixed timetable: a worker that falls behind does not skip
+// operations, it issues them late, and since their latency is measured from
+// the intended start the time spent waiting is accounted for (correcting
+// for coordinated omission).
+func (w *worker) loop(sched schedule, workers int, op func(intended time.Time)) {
+	begin := time.Now()
+	deadline := begin.Add(sched.duration)
+
+	var interval time.Duration
+	next := begin
+	if sched.rate > 0 {
+		interval = time.Duration(float64(workers) / sched.rate * float64(time.Second))
+		// Stagger the workers so they don't all fire at once.
+		next = begin.Add(interval * time.Duration(w.id) / time.Duration(workers))
+	}
+
+	for iter := 0; sched.duration > 0 || iter < sched.iterations; iter++ {
+		if sched.duration > 0 && !next.Before(deadline) {
+			return
+		}
+		if interval > 0 {
+			if wait := next.Sub(time.Now()); wait > 0 {
+				time.Sleep(wait)
+			}
+			op(next)
+			next = next.Add(interval)
+			continue
+		}
+		op(time.Now())
+		next = time.Now()
+	}
+}
+
+// execStep runs a single iteration of the given step and records it under
+// the given step index.
+func (w *worker) execStep(index int, step *WorkloadStep) {
+	w.execStepAt(index, step, time.Now())
+}
+
+// execStepAt is execStep for an operation that was scheduled to start at
+// intended.  Its latency is measured from that time, not from when it
+// actually started.
+func (w *worker) execStepAt(index int, step *WorkloadStep, intended time.Time) {
+	if step.Transaction != nil {
+		w.execTransactionAt(index, step, intended)
+		return
+	}
+
+	start := time.Now()
+	var failures [errorClasses]int
+	var result sample
+	attempt := 0
+	for ; ; attempt++ {
+		var err error
+		result, err = w.exec(nil, index, step)
+		if err == nil {
+			break
+		}
+		failures[classifyError(err)]++
+		if !w.retryError(step, err, attempt) {
+			result.failed = true
+			break
+		}
+	}
+	result.failures, result.retries = failures, attempt
+	result.busy = time.Since(start)
+	result.latency = time.Since(intended)
+	w.record(index, result)
+}
+
+// exec executes the statement of a step once, within tx unless it is nil,
+// and returns the rows and bytes it read or the rows it changed.
+func (w *worker) exec(tx *sql.Tx, index int, step *WorkloadStep) (sample, error) {
+	if step.Bulk != "" {
+		return w.execBulk(tx, index, step, step.Batch)
+	}
+	result := sample{}
+
+	args := w.args[:0]
+	if step.generator != nil {
+		args = append(args, step.generator.row(w.rng)...)
+	}
+	for _, param := range step.params {
+		args = append(args, param.next(w.rng))
+	}
+	w.args = args
+
+	var stmt *sql.Stmt
+	if w.prepared {
+		var err error
+		if stmt, err = w.statementIn(tx, index, step); err != nil {
+			return result, err
+		}
+	}
+
+	var rows *sql.Rows
+	var res sql.Result
+	var err error
+	switch {
+	case stmt != nil && step.returnsRows:
+		rows, err = stmt.Query(args...)
+	case stmt != nil:
+		res, err = stmt.Exec(args...)
+	case tx != nil && step.returnsRows:
+		rows, err = tx.Query(step.query, args...)
+	case tx != nil:
+		res, err = tx.Exec(step.query, args...)
+	case step.returnsRows:
+		rows, err = w.dbFor(step).Query(step.query, args...)
+	default:
+		res, err = w.dbFor(step).Exec(step.query, args...)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	if rows != nil {
+		err := processData(&w.rows, rows)
+		rows.Close()
+		if err != nil {
+			return result, err
+		}
+		result.rows, result.bytes = w.rows.rows, w.rows.bytes
+	} else if affected, err := res.RowsAffected(); err == nil {
+		result.rows = affected
+	}
+	return result, nil
+}
+
+// sample is the outcome of a single execution of a step.
+type sample struct {
+	busy    time.Duration
+	latency time.Duration
+	rows    int64
+	bytes   int64
+	failed  bool
+	// failures counts the errors of the execution and of its retries.
+	failures [errorClasses]int
+	retries  int
+	// The outcome of a transaction.
+	commits   int
+	rollbacks int
+}
+
+// record accounts for a single execution of a step.
+func (w *worker) record(index int, result sample) {
+	w.mu.Lock()
+	w.steps[index].record(result)
+	w.interval[index].record(result)
+	w.mu.Unlock()
+}
+
+// recordTransaction accounts for a single execution of a transaction step.
+// Interval reports count queries, so only the statements of a transaction
+// show up in them.
+func (w *worker) recordTransaction(index int, result sample) {
+	w.mu.Lock()
+	w.steps[index].record(result)
+	w.mu.Unlock()
+}
+
+func (s *stepStats) record(result sample) {
+	s.iterations++
+	s.rows += result.rows
+	s.bytes += result.bytes
+	s.busy += result.busy
+	s.latency.record(result.latency)
+	if result.failed {
+		s.errors++
+	}
+	for i, count := range result.failures {
+		s.failures[i] += count
+	}
+	s.retries += result.retries
+	s.commits += result.commits
+	s.rollbacks += result.rollbacks
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolRun(t *testing.T) {
+	pool := &workerPool{}
+	for id := 0; id < 8; id++ {
+		pool.workers = append(pool.workers, newWorker(id, nil, 2))
+	}
+
+	var started int32
+	pool.run(func(w *worker) {
+		atomic.AddInt32(&started, 1)
+		w.steps[0].iterations += w.id
+		w.steps[1].busy += time.Millisecond
+	})
+	if started != 8 {
+		t.Fatalf("run() started %d workers, want 8", started)
+	}
+
+	stats := pool.stats()
+	if stats[0].iterations != 28 || stats[1].busy != 8*time.Millisecond {
+		t.Errorf("stats() = %+v, want 28 iterations and 8ms busy", stats)
+	}
+
+	pool.reset()
+	if stats := pool.stats(); stats[0].iterations != 0 || stats[1].busy != 0 {
+		t.Errorf("stats() after reset() = %+v, want zero values", stats)
+	}
+}
+
+func TestWorkerLoop(t *testing.T) {
+	w := newWorker(0, nil, 1)
+
+	calls := 0
+	w.loop(schedule{iterations: 25}, 1, func(intended time.Time) { calls++ })
+	if calls != 25 {
+		t.Errorf("closed-loop loop() made %d calls, want 25", calls)
+	}
+
+	calls = 0
+	start := time.Now()
+	w.loop(schedule{iterations: 1, duration: 50 * time.Millisecond}, 1, func(intended time.Time) {
+		calls++
+		time.Sleep(time.Millisecond)
+	})
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || calls < 2 {
+		t.Errorf("duration loop() ran %d calls in %s, want to run for 50ms", calls, elapsed)
+	}
+
+	var intended []time.Time
+	w.loop(schedule{duration: 100 * time.Millisecond, rate: 200}, 1, func(at time.Time) {
+		intended = append(intended, at)
+	})
+	if len(intended) != 20 {
+		t.Errorf("rate limited loop() made %d calls, want 20", len(intended))
+	}
+	for i := 1; i < len(intended); i++ {
+		if gap := intended[i].Sub(intended[i-1]); gap != 5*time.Millisecond {
+			t.Fatalf("rate limited loop() scheduled calls %s apart, want 5ms", gap)
+		}
+	}
+}
+
+func TestIntervalReporter(t *testing.T) {
+	pool := &workerPool{workers: []*worker{newWorker(0, nil, 1), newWorker(1, nil, 1)}}
+	intervalBuffer = nil
+	defer func() { intervalBuffer = nil }()
+
+	reporter := startIntervalReporter(pool, time.Hour, nil)
+	pool.run(func(w *worker) {
+		for i := 0; i < 10; i++ {
+			w.record(0, sample{busy: time.Millisecond, latency: time.Millisecond, rows: 2, failed: i == 0})
+		}
+	})
+	reporter.Stop()
+
+	if len(intervalBuffer) != 1 {
+		t.Fatalf("reporter recorded %d intervals, want 1", len(intervalBuffer))
+	}
+	if row := intervalBuffer[0]; row[1] != "20" || row[3] != "2" {
+		t.Errorf("interval row = %v, want 20 queries and 2 errors", row)
+	}
+	if stats := pool.stats(); stats[0].iterations != 20 || stats[0].rows != 40 {
+		t.Errorf("stats() after interval = %+v, want 20 iterations and 40 rows", stats[0])
+	}
+	if startIntervalReporter(pool, 0, nil) != nil {
+		t.Errorf("startIntervalReporter(pool, 0, nil) started a reporter")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"database/sql/driver"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/opendns/lemming/lib/log"
+)
+
+// errorClass groups failed operations by their cause.
+type errorClass int
+
+const (
+	errorOther errorClass = iota
+	errorDuplicateKey
+	errorDeadlock
+	errorLockWaitTimeout
+	errorConnectionLost
+	errorSyntax
+	errorTooManyConnections
+	// errorClasses is the number of error classes.
+	errorClasses
+)
+
+var errorClassNames = [errorClasses]string{"Other", "Duplicate Key", "Deadlock", "Lock Wait Timeout", "Connection Lost", "Syntax", "Too Many Connections"}
+
+// MySQL error numbers lomax classifies.
+const (
+	errDupKeyName         = 1022
+	errConCount           = 1040
+	errServerShutdown     = 1053
+	errDupEntry           = 1062
+	errParseError         = 1064
+	errSyntaxError        = 1149
+	errTooManyUserConns   = 1203
+	errLockWaitTimeout    = 1205
+	errDeadlock           = 1213
+	errDupEntryWithKey    = 1586
+	errConnectionKilled   = 1927
+	errServerGone         = 2006
+	errServerLost         = 2013
+	errServerLostExtended = 2055
+)
+
+// The --onerror policies.
+const (
+	onErrorAbort    = "abort"
+	onErrorContinue = "continue"
+	onErrorRetry    = "retry"
+)
+
+var onErrorPtr string
+var retriesPtr int
+var backoffPtr time.Duration
+
+// errorBuffer holds one row per reported function that had failures.
+var errorBuffer [][]string
+var errorHeader = append([]string{"Function", "Errors", "Retries"}, errorClassNames[:]...)
+
+func init() {
+	flag.StringVar(&onErrorPtr, "onerror", onErrorContinue, "What to do when a query fails: abort the run, continue with the next query, or retry deadlocks, lock wait timeouts and lost connections.")
+	flag.IntVar(&retriesPtr, "retries", 3, "With --onerror=retry, the maximum number of times a failed query is retried.")
+	flag.DurationVar(&backoffPtr, "backoff", 10*time.Millisecond, "The wait before the first retry of a query with --onerror=retry, or of a deadlocked transaction.  It doubles with every further retry, and up to half of it is dropped at random.")
+}
+
+// mysqlErrorNumber returns the MySQL error number of err, or 0 if it is not
+// an error reported by the server.
+func mysqlErrorNumber(err error) uint16 {
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+		return mysqlErr.Number
+	}
+	return 0
+}
+
+func classifyError(err error) errorClass {
+	switch mysqlErrorNumber(err) {
+	case errDupKeyName, errDupEntry, errDupEntryWithKey:
+		return errorDuplicateKey
+	case errDeadlock:
+		return errorDeadlock
+	case errLockWaitTimeout:
+		return errorLockWaitTimeout
+	case errParseError, errSyntaxError:
+		return errorSyntax
+	case errConCount, errTooManyUserConns:
+		return errorTooManyConnections
+	case errServerShutdown, errConnectionKilled, errServerGone, errServerLost, errServerLostExtended:
+		return errorConnectionLost
+	}
+	if err == driver.ErrBadConn || err == mysql.ErrInvalidConn || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errorConnectionLost
+	}
+	if _, ok := err.(net.Error); ok {
+		return errorConnectionLost
+	}
+	return errorOther
+}
+
+// transient reports whether an operation failing with an error of the class
+// may succeed when it is retried.
+func (class errorClass) transient() bool {
+	return class == errorDeadlock || class == errorLockWaitTimeout || class == errorConnectionLost
+}
+
+// validOnError reports whether policy is a known --onerror policy.
+func validOnError(policy string) bool {
+	return policy == onErrorAbort || policy == onErrorContinue || policy == onErrorRetry
+}
+
+// retryError applies the --onerror policy to an operation of step that
+// failed with err after attempt retries, and reports whether to retry it.
+// With the abort policy it does not return.
+func (w *worker) retryError(step *WorkloadStep, err error, attempt int) bool {
+	if onErrorPtr == onErrorRetry && classifyError(err).transient() && attempt < retriesPtr {
+		w.lastErr = err
+		w.backoff(attempt)
+		return true
+	}
+	w.failError(step, err)
+	return false
+}
+
+// failError applies the --onerror policy to an operation of step that
+// failed with err and is not retried.  With the abort policy it does not
+// return.
+func (w *worker) failError(step *WorkloadStep, err error) {
+	w.lastErr = err
+	class := classifyError(err)
+	if onErrorPtr == onErrorAbort {
+		log.Error("[%s]: %s: %s", GetFunctionName((*worker).failError), step.describe(), err.Error())
+	}
+
+	// Every step logs the first error of each class, the others are only
+	// counted.
+	if atomic.CompareAndSwapInt32(&step.warned[class], 0, 1) {
+		log.Warning(fmt.Sprintf("[%s]: %s: %s; further %s errors of this step are only counted", GetFunctionName((*worker).failError), step.describe(), err.Error(), errorClassNames[class]))
+	}
+}
+
+// backoff waits before retry attempt+1: --backoff, doubled with every
+// further retry, of which a random half is dropped so that workers that
+// collided do not collide again on their retries.
+func (w *worker) backoff(attempt int) {
+	wait := backoffPtr << uint(attempt)
+	if wait <= 0 {
+		return
+	}
+	time.Sleep(wait/2 + time.Duration(w.rng.Int63n(int64(wait/2)+1)))
+}
+
+// collectErrors adds a row to the error report if stats had any failures.
+func collectErrors(name string, stats *stepStats) {
+	if stats == nil || (stats.errors == 0 && stats.retries == 0) {
+		return
+	}
+	row := []string{name, fmt.Sprintf("%d", stats.errors), fmt.Sprintf("%d", stats.retries)}
+	for _, count := range stats.failures {
+		row = append(row, fmt.Sprintf("%d", count))
+	}
+	errorBuffer = append(errorBuffer, row)
+	resultDoc.Errors = append(resultDoc.Errors, newErrorRecord(name, stats))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want errorClass
+	}{
+		{&mysql.MySQLError{Number: errDupEntry, Message: "Duplicate entry '1' for key 'PRIMARY'"}, errorDuplicateKey},
+		{&mysql.MySQLError{Number: errDeadlock, Message: "Deadlock found"}, errorDeadlock},
+		{&mysql.MySQLError{Number: errLockWaitTimeout, Message: "Lock wait timeout exceeded"}, errorLockWaitTimeout},
+		{&mysql.MySQLError{Number: errParseError, Message: "You have an error in your SQL syntax"}, errorSyntax},
+		{&mysql.MySQLError{Number: errServerGone, Message: "MySQL server has gone away"}, errorConnectionLost},
+		{&mysql.MySQLError{Number: errConCount, Message: "Too many connections"}, errorTooManyConnections},
+		{&mysql.MySQLError{Number: 1146, Message: "Table doesn't exist"}, errorOther},
+		{driver.ErrBadConn, errorConnectionLost},
+		{mysql.ErrInvalidConn, errorConnectionLost},
+		{&net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, errorConnectionLost},
+		{errors.New("something else"), errorOther},
+	}
+	for _, c := range cases {
+		if got := classifyError(c.err); got != c.want {
+			t.Errorf("classifyError(%v) = %s, want %s", c.err, errorClassNames[got], errorClassNames[c.want])
+		}
+	}
+	if n := mysqlErrorNumber(errors.New("driver: bad connection")); n != 0 {
+		t.Errorf("mysqlErrorNumber(non-server error) = %d, want 0", n)
+	}
+}
+
+func TestRetryError(t *testing.T) {
+	defer func(policy string, retries int, backoff time.Duration) {
+		onErrorPtr, retriesPtr, backoffPtr = policy, retries, backoff
+	}(onErrorPtr, retriesPtr, backoffPtr)
+	retriesPtr, backoffPtr = 2, time.Millisecond
+
+	w := newWorker(0, nil, 1)
+	step := &WorkloadStep{Action: "UPDATE", Table: "salaries"}
+	deadlock := &mysql.MySQLError{Number: errDeadlock, Message: "Deadlock found"}
+	duplicate := &mysql.MySQLError{Number: errDupEntry, Message: "Duplicate entry"}
+
+	onErrorPtr = onErrorContinue
+	if w.retryError(step, deadlock, 0) {
+		t.Errorf("continue policy retried a deadlock")
+	}
+	if step.warned[errorDeadlock] != 1 || w.lastErr != deadlock {
+		t.Errorf("continue policy did not log and keep the deadlock")
+	}
+
+	onErrorPtr = onErrorRetry
+	if !w.retryError(step, deadlock, 0) || !w.retryError(step, deadlock, 1) || w.retryError(step, deadlock, 2) {
+		t.Errorf("retry policy did not retry a deadlock exactly --retries times")
+	}
+	if w.retryError(step, duplicate, 0) {
+		t.Errorf("retry policy retried a duplicate key error")
+	}
+
+	onErrorPtr = onErrorAbort
+	defer func() {
+		if recover() == nil {
+			t.Errorf("abort policy did not abort")
+		}
+	}()
+	w.retryError(step, duplicate, 0)
+}
+
+func TestBackoff(t *testing.T) {
+	defer func(backoff time.Duration) { backoffPtr = backoff }(backoffPtr)
+	backoffPtr = 2 * time.Millisecond
+
+	w := newWorker(0, nil, 1)
+	for attempt := 0; attempt < 3; attempt++ {
+		start := time.Now()
+		w.backoff(attempt)
+		if waited, min := time.Since(start), backoffPtr<<uint(attempt)/2; waited < min {
+			t.Errorf("backoff(%d) waited %s, want at least %s", attempt, waited, min)
+		}
+	}
+}
+
+func TestCollectErrors(t *testing.T) {
+	errorBuffer = nil
+	stats := newStepStats(1)[0]
+	collectErrors("clean", &stats)
+	stats.errors, stats.retries = 1, 2
+	stats.failures[errorDeadlock], stats.failures[errorDuplicateKey] = 2, 1
+	collectErrors("failing", &stats)
+
+	if len(errorBuffer) != 1 {
+		t.Fatalf("collectErrors() added %d rows, want 1", len(errorBuffer))
+	}
+	if row := errorBuffer[0]; len(row) != len(errorHeader) || row[1] != "1" || row[3+errorDeadlock] != "2" || row[3+errorDuplicateKey] != "1" {
+		t.Errorf("collectErrors() = %v", row)
+	}
+	errorBuffer = nil
+}
@@ -0,0 +1,766 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/enodata/faker"
+)
+
+// GeneratorSpec overrides how random values are generated for a column.
+// It is set per column in the "generators" object of a workload step:
+//
+//	"generators": {
+//	  "emp_no":     {"type": "sequence", "start": 500000},
+//	  "salary":     {"type": "uniform", "min": 40000, "max": 150000},
+//	  "dept_no":    {"type": "set", "values": ["d001", "d002", "d003"]},
+//	  "first_name": {"type": "faker", "category": "first_name"},
+//	  "score":      {"type": "zipfian", "min": 1, "max": 1000, "skew": 1.2, "null_ratio": 0.1}
+//	}
+//
+// The key distributions uniform, zipfian, hotspot, latest, sequential and
+// exponential also pick the keys of point lookups, see Key.
+type GeneratorSpec struct {
+	// Type is one of sequence, uniform, zipfian, hotspot, latest,
+	// sequential, exponential, faker or set.
+	Type string `json:"type"`
+	// Start and Step configure a sequence.  Step defaults to 1.
+	Start int64 `json:"start"`
+	Step  int64 `json:"step"`
+	// Min and Max bound the values of the key distributions.
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	// Key takes Min and Max from the MIN and MAX of a column instead:
+	// "table.column", or "table" for the first column of its primary key.
+	// The params of a step with a table and neither min nor max default
+	// to the table's primary key.
+	Key string `json:"key"`
+	// Skew is the exponent of zipfian and latest, which must be greater
+	// than 1.
+	Skew float64 `json:"skew"`
+	// HotTraffic is the fraction of hotspot and exponential values that
+	// fall on the HotKeys fraction of keys at the start of the range.
+	// They default to 0.8 and 0.2.
+	HotKeys    float64 `json:"hot_keys"`
+	HotTraffic float64 `json:"hot_traffic"`
+	// Category is the faker category, e.g. first_name or email.
+	Category string `json:"category"`
+	// Values is the fixed set of values to pick from.
+	Values []interface{} `json:"values"`
+	// NullRatio is the fraction of values that are NULL.
+	NullRatio float64 `json:"null_ratio"`
+}
+
+// columnInfo describes a column as reported by information_schema.COLUMNS.
+type columnInfo struct {
+	name          string
+	dataType      string
+	columnType    string
+	maxLength     int64
+	precision     int64
+	scale         int64
+	nullable      bool
+	autoIncrement bool
+	unique        bool
+	primary       bool
+}
+
+// valueGenerator produces random values for a single column.  Generators are
+// shared by all workers, so any state they keep must be safe for concurrent
+// use; randomness comes from the calling worker's rng.
+type valueGenerator interface {
+	next(rng *rand.Rand) interface{}
+}
+
+// rowGenerator produces random rows for the columns of a table.
+type rowGenerator struct {
+	table      string
+	columns    []columnInfo
+	generators []valueGenerator
+}
+
+var fakerCategories = map[string]func() string{
+	"first_name": func() string { return faker.Name().FirstName() },
+	"last_name":  func() string { return faker.Name().LastName() },
+	"name":       func() string { return faker.Name().Name() },
+	"title":      func() string { return faker.Name().Title() },
+	"team":       func() string { return faker.Team().Name() },
+	"company":    func() string { return faker.Company().Name() },
+	"city":       func() string { return faker.Address().City() },
+	"country":    func() string { return faker.Address().Country() },
+	"email":      func() string { return faker.Internet().Email() },
+	"username":   func() string { return faker.Internet().UserName() },
+	"ipv4":       func() string { return faker.Internet().IpV4Address() },
+	"phone":      func() string { return faker.PhoneNumber().PhoneNumber() },
+	"word":       func() string { return faker.Lorem().Word() },
+	"sentence":   func() string { return faker.Lorem().Sentence(6) },
+}
+
+// Matches the quoted values of an enum or set column type.
+//
+//	$1: value, with quotes doubled
+var enumValueRegexp = regexp.MustCompile(`'((?:[^']|'')*)'`)
+
+const alphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+type sequenceGenerator struct {
+	counter int64
+	step    int64
+}
+
+func (g *sequenceGenerator) next(rng *rand.Rand) interface{} {
+	return atomic.AddInt64(&g.counter, g.step) - g.step
+}
+
+// uniqueStringGenerator produces distinct strings for unique columns: a
+// fixed prefix followed by a counter.
+type uniqueStringGenerator struct {
+	prefix    string
+	counter   int64
+	maxLength int64
+}
+
+func (g *uniqueStringGenerator) next(rng *rand.Rand) interface{} {
+	return truncate(g.prefix+strconv.FormatInt(atomic.AddInt64(&g.counter, 1), 36), g.maxLength)
+}
+
+type uniformIntGenerator struct {
+	min, max int64
+}
+
+func (g *uniformIntGenerator) next(rng *rand.Rand) interface{} {
+	if g.max <= g.min {
+		return g.min
+	}
+	return g.min + rng.Int63n(g.max-g.min+1)
+}
+
+type uniformFloatGenerator struct {
+	min, max float64
+}
+
+func (g *uniformFloatGenerator) next(rng *rand.Rand) interface{} {
+	return g.min + rng.Float64()*(g.max-g.min)
+}
+
+// keyDistributions are the generator types that pick integers from a range
+// of keys.
+var keyDistributions = map[string]bool{"uniform": true, "zipfian": true, "hotspot": true, "latest": true, "sequential": true, "exponential": true}
+
+type zipfianGenerator struct {
+	min  int64
+	max  int64
+	skew float64
+	// latest makes max the most frequent value instead of min, for keys
+	// that are hot while they are new.
+	latest bool
+	// zipf caches one math/rand Zipf per worker rng, since a Zipf is bound
+	// to the rng it was created with.
+	zipf sync.Map
+}
+
+func (g *zipfianGenerator) next(rng *rand.Rand) interface{} {
+	z, ok := g.zipf.Load(rng)
+	if !ok {
+		z, _ = g.zipf.LoadOrStore(rng, rand.NewZipf(rng, g.skew, 1, uint64(g.max-g.min)))
+	}
+	if g.latest {
+		return g.max - int64(z.(*rand.Zipf).Uint64())
+	}
+	return g.min + int64(z.(*rand.Zipf).Uint64())
+}
+
+// hotspotGenerator picks traffic of its values from the hot keys at the
+// start of the range and the others from the rest, uniformly within each.
+type hotspotGenerator struct {
+	min     int64
+	size    int64
+	hot     int64
+	traffic float64
+}
+
+func (g *hotspotGenerator) next(rng *rand.Rand) interface{} {
+	if g.hot >= g.size || rng.Float64() < g.traffic {
+		return g.min + rng.Int63n(g.hot)
+	}
+	return g.min + g.hot + rng.Int63n(g.size-g.hot)
+}
+
+// sequentialGenerator cycles through the range in order.
+type sequentialGenerator struct {
+	min     int64
+	size    int64
+	counter int64
+}
+
+func (g *sequentialGenerator) next(rng *rand.Rand) interface{} {
+	return g.min + (atomic.AddInt64(&g.counter, 1)-1)%g.size
+}
+
+// exponentialGenerator picks values whose frequency decays exponentially
+// from the start of the range.
+type exponentialGenerator struct {
+	min  int64
+	size int64
+	// rate is the decay per key.
+	rate float64
+}
+
+func (g *exponentialGenerator) next(rng *rand.Rand) interface{} {
+	return g.min + int64(rng.ExpFloat64()/g.rate)%g.size
+}
+
+type setGenerator struct {
+	values []interface{}
+}
+
+func (g *setGenerator) next(rng *rand.Rand) interface{} {
+	return g.values[rng.Intn(len(g.values))]
+}
+
+type fakerGenerator struct {
+	generate  func() string
+	maxLength int64
+}
+
+func (g *fakerGenerator) next(rng *rand.Rand) interface{} {
+	return truncate(g.generate(), g.maxLength)
+}
+
+type stringGenerator struct {
+	maxLength int64
+}
+
+func (g *stringGenerator) next(rng *rand.Rand) interface{} {
+	length := g.maxLength
+	if length > 32 {
+		length = 32
+	}
+	buf := make([]byte, 1+rng.Int63n(length))
+	for i := range buf {
+		buf[i] = alphanumeric[rng.Intn(len(alphanumeric))]
+	}
+	return string(buf)
+}
+
+type decimalGenerator struct {
+	precision, scale int64
+}
+
+func (g *decimalGenerator) next(rng *rand.Rand) interface{} {
+	digits := g.precision - g.scale
+	if digits > 15 {
+		digits = 15
+	}
+	integer := rng.Int63n(int64(math.Pow10(int(digits))))
+	if g.scale == 0 {
+		return strconv.FormatInt(integer, 10)
+	}
+	scale := g.scale
+	if scale > 15 {
+		scale = 15
+	}
+	fraction := rng.Int63n(int64(math.Pow10(int(scale))))
+	return fmt.Sprintf("%d.%0*d", integer, scale, fraction)
+}
+
+type timeGenerator struct {
+	from, to time.Time
+	layout   string
+}
+
+func (g *timeGenerator) next(rng *rand.Rand) interface{} {
+	span := g.to.Unix() - g.from.Unix()
+	return time.Unix(g.from.Unix()+rng.Int63n(span), 0).UTC().Format(g.layout)
+}
+
+type nullableGenerator struct {
+	ratio float64
+	valueGenerator
+}
+
+func (g *nullableGenerator) next(rng *rand.Rand) interface{} {
+	if rng.Float64() < g.ratio {
+		return nil
+	}
+	return g.valueGenerator.next(rng)
+}
+
+func truncate(value string, maxLength int64) string {
+	if maxLength > 0 && int64(len(value)) > maxLength {
+		return value[:maxLength]
+	}
+	return value
+}
+
+// integerMax returns the largest value an integer column can hold.
+func integerMax(column columnInfo) int64 {
+	bits := map[string]uint{"tinyint": 8, "smallint": 16, "mediumint": 24, "int": 32, "integer": 32, "bigint": 64}[column.dataType]
+	if bits == 64 {
+		return math.MaxInt64
+	}
+	if strings.Contains(column.columnType, "unsigned") {
+		return 1<<bits - 1
+	}
+	return 1<<(bits-1) - 1
+}
+
+func isDecimal(column columnInfo) bool {
+	switch column.dataType {
+	case "decimal", "numeric", "float", "double", "real":
+		return true
+	}
+	return false
+}
+
+func isInteger(column columnInfo) bool {
+	switch column.dataType {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint":
+		return true
+	}
+	return false
+}
+
+// defaultGenerator returns a generator producing valid values of the
+// column's type.  nextID is the first unused value of unique numeric
+// columns.  Unique columns of other types get distinct strings, except for
+// temporal and enumerated types, which need a generator override.
+func defaultGenerator(column columnInfo, nextID int64) (valueGenerator, error) {
+	if column.unique {
+		return uniqueGenerator(column, nextID)
+	}
+	if isInteger(column) {
+		// Keep the values readable, but within the column's range.
+		max := integerMax(column)
+		if max > 1000000 {
+			max = 1000000
+		}
+		return &uniformIntGenerator{min: 0, max: max}, nil
+	}
+
+	switch column.dataType {
+	case "decimal", "numeric":
+		return &decimalGenerator{precision: column.precision, scale: column.scale}, nil
+	case "float", "double", "real":
+		return &uniformFloatGenerator{min: 0, max: 1000000}, nil
+	case "bit", "bool", "boolean":
+		return &uniformIntGenerator{min: 0, max: 1}, nil
+	case "date":
+		return &timeGenerator{from: time.Date(1950, 1, 1, 0, 0, 0, 0, time.UTC), to: time.Now(), layout: "2006-01-02"}, nil
+	case "datetime":
+		return &timeGenerator{from: time.Date(1950, 1, 1, 0, 0, 0, 0, time.UTC), to: time.Now(), layout: "2006-01-02 15:04:05"}, nil
+	case "timestamp":
+		return &timeGenerator{from: time.Date(1971, 1, 1, 0, 0, 0, 0, time.UTC), to: time.Now(), layout: "2006-01-02 15:04:05"}, nil
+	case "time":
+		return &timeGenerator{from: time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), to: time.Date(1970, 1, 2, 0, 0, 0, 0, time.UTC), layout: "15:04:05"}, nil
+	case "year":
+		return &uniformIntGenerator{min: 1901, max: 2155}, nil
+	case "enum", "set":
+		var values []interface{}
+		for _, match := range enumValueRegexp.FindAllStringSubmatch(column.columnType, -1) {
+			values = append(values, strings.Replace(match[1], "''", "'", -1))
+		}
+		if len(values) > 0 {
+			return &setGenerator{values: values}, nil
+		}
+	case "json":
+		return &setGenerator{values: []interface{}{`{}`, `[]`, `{"lomax": true}`}}, nil
+	}
+
+	maxLength := column.maxLength
+	if maxLength <= 0 {
+		maxLength = 16
+	}
+	return &stringGenerator{maxLength: maxLength}, nil
+}
+
+// uniqueCounterDigits is the room unique strings keep for their counter.
+const uniqueCounterDigits = 8
+
+// uniqueGenerator returns the default generator of a unique column.
+func uniqueGenerator(column columnInfo, nextID int64) (valueGenerator, error) {
+	if isInteger(column) || isDecimal(column) {
+		return &sequenceGenerator{counter: nextID, step: 1}, nil
+	}
+	switch column.dataType {
+	case "char", "varchar", "binary", "varbinary", "tinytext", "text", "mediumtext", "longtext", "tinyblob", "blob", "mediumblob", "longblob":
+		// The prefix tells the values of different runs apart, as far as the
+		// column leaves room for it next to 8 digits of the counter.
+		prefix := strconv.FormatInt(time.Now().UnixNano(), 36) + "-"
+		if column.maxLength > 0 {
+			room := column.maxLength - uniqueCounterDigits
+			if room < 0 {
+				room = 0
+			}
+			if int64(len(prefix)) > room {
+				prefix = prefix[int64(len(prefix))-room:]
+			}
+		}
+		return &uniqueStringGenerator{prefix: prefix, maxLength: column.maxLength}, nil
+	}
+	return nil, fmt.Errorf("column %s: can not generate distinct values of unique %s columns, please set a generator for it", column.name, column.dataType)
+}
+
+// newValueGenerator builds the generator described by spec for column.
+func newValueGenerator(column columnInfo, spec GeneratorSpec) (valueGenerator, error) {
+	var generator valueGenerator
+	switch strings.ToLower(spec.Type) {
+	case "sequence":
+		step := spec.Step
+		if step == 0 {
+			step = 1
+		}
+		generator = &sequenceGenerator{counter: spec.Start, step: step}
+	case "uniform":
+		if spec.Max < spec.Min {
+			return nil, fmt.Errorf("column %s: uniform max %v is below min %v", column.name, spec.Max, spec.Min)
+		}
+		if isInteger(column) {
+			generator = &uniformIntGenerator{min: int64(spec.Min), max: int64(spec.Max)}
+		} else {
+			generator = &uniformFloatGenerator{min: spec.Min, max: spec.Max}
+		}
+	case "zipfian":
+		skew := spec.Skew
+		if skew == 0 {
+			skew = 1.1
+		}
+		if skew <= 1 || spec.Max <= spec.Min {
+			return nil, fmt.Errorf("column %s: zipfian needs a skew above 1 and max above min", column.name)
+		}
+		generator = &zipfianGenerator{min: int64(spec.Min), max: int64(spec.Max), skew: skew}
+	case "latest":
+		skew := spec.Skew
+		if skew == 0 {
+			skew = 1.1
+		}
+		if skew <= 1 || spec.Max <= spec.Min {
+			return nil, fmt.Errorf("column %s: latest needs a skew above 1 and max above min", column.name)
+		}
+		generator = &zipfianGenerator{min: int64(spec.Min), max: int64(spec.Max), skew: skew, latest: true}
+	case "sequential":
+		if spec.Max < spec.Min {
+			return nil, fmt.Errorf("column %s: sequential max %v is below min %v", column.name, spec.Max, spec.Min)
+		}
+		generator = &sequentialGenerator{min: int64(spec.Min), size: int64(spec.Max) - int64(spec.Min) + 1}
+	case "hotspot", "exponential":
+		hotKeys, hotTraffic := spec.HotKeys, spec.HotTraffic
+		if hotKeys == 0 {
+			hotKeys = 0.2
+		}
+		if hotTraffic == 0 {
+			hotTraffic = 0.8
+		}
+		// All values of an exponential can not fall on the hot keys.
+		exponential := strings.ToLower(spec.Type) == "exponential"
+		if spec.Max < spec.Min || hotKeys < 0 || hotKeys > 1 || hotTraffic < 0 || hotTraffic > 1 || exponential && hotTraffic == 1 {
+			return nil, fmt.Errorf("column %s: %s needs max above min and hot_keys and hot_traffic between 0 and 1", column.name, spec.Type)
+		}
+		size := int64(spec.Max) - int64(spec.Min) + 1
+		hot := int64(float64(size) * hotKeys)
+		if hot < 1 {
+			hot = 1
+		}
+		if !exponential {
+			generator = &hotspotGenerator{min: int64(spec.Min), size: size, hot: hot, traffic: hotTraffic}
+		} else {
+			// hotTraffic of the values fall within the first hot keys.
+			generator = &exponentialGenerator{min: int64(spec.Min), size: size, rate: -math.Log(1-hotTraffic) / float64(hot)}
+		}
+	case "faker":
+		generate, ok := fakerCategories[strings.ToLower(spec.Category)]
+		if !ok {
+			return nil, fmt.Errorf("column %s: unknown faker category %q", column.name, spec.Category)
+		}
+		generator = &fakerGenerator{generate: generate, maxLength: column.maxLength}
+	case "set":
+		if len(spec.Values) == 0 {
+			return nil, fmt.Errorf("column %s: set has no values", column.name)
+		}
+		generator = &setGenerator{values: spec.Values}
+	case "":
+		generator = nil
+	default:
+		return nil, fmt.Errorf("column %s: unknown generator type %q", column.name, spec.Type)
+	}
+
+	if spec.NullRatio < 0 || spec.NullRatio > 1 {
+		return nil, fmt.Errorf("column %s: null_ratio must be between 0 and 1", column.name)
+	}
+	if spec.NullRatio > 0 && generator != nil {
+		generator = &nullableGenerator{ratio: spec.NullRatio, valueGenerator: generator}
+	}
+	return generator, nil
+}
+
+// describeTable reads the column definitions of a table in the current
+// database from information_schema.
+func describeTable(db *sql.DB, table string) ([]columnInfo, error) {
+	rows, err := db.Query(`SELECT COLUMN_NAME, DATA_TYPE, COLUMN_TYPE, COALESCE(CHARACTER_MAXIMUM_LENGTH, 0),
+		COALESCE(NUMERIC_PRECISION, 0), COALESCE(NUMERIC_SCALE, 0), IS_NULLABLE, EXTRA, COLUMN_KEY
+		FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []columnInfo
+	for rows.Next() {
+		var column columnInfo
+		var nullable, extra, key string
+		if err := rows.Scan(&column.name, &column.dataType, &column.columnType, &column.maxLength, &column.precision, &column.scale, &nullable, &extra, &key); err != nil {
+			return nil, err
+		}
+		column.dataType = strings.ToLower(column.dataType)
+		column.columnType = strings.ToLower(column.columnType)
+		column.nullable = nullable == "YES"
+		column.autoIncrement = strings.Contains(strings.ToLower(extra), "auto_increment")
+		column.primary = key == "PRI"
+		columns = append(columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s not found in the current database", table)
+	}
+
+	indexes, err := uniqueIndexes(db, table)
+	if err != nil {
+		return nil, err
+	}
+	markUnique(columns, indexes)
+	return columns, nil
+}
+
+// uniqueIndexes returns the columns of every unique index of a table in the
+// current database, keyed by index name.
+func uniqueIndexes(db *sql.DB, table string) (map[string][]string, error) {
+	rows, err := db.Query(`SELECT INDEX_NAME, COLUMN_NAME FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND NON_UNIQUE = 0 ORDER BY INDEX_NAME, SEQ_IN_INDEX`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	indexes := make(map[string][]string)
+	for rows.Next() {
+		var index, column string
+		if err := rows.Scan(&index, &column); err != nil {
+			return nil, err
+		}
+		indexes[index] = append(indexes[index], column)
+	}
+	return indexes, rows.Err()
+}
+
+// markUnique flags the columns that form a unique index on their own.  The
+// columns of a composite primary key or unique index are not unique by
+// themselves, e.g. emp_no and from_date of salaries.
+func markUnique(columns []columnInfo, indexes map[string][]string) {
+	for _, index := range indexes {
+		if len(index) != 1 {
+			continue
+		}
+		for i := range columns {
+			if strings.EqualFold(columns[i].name, index[0]) {
+				columns[i].unique = true
+			}
+		}
+	}
+}
+
+// newRowGenerator introspects table and builds a generator for its columns.
+// If columns is not empty only the listed columns are generated; otherwise
+// every column except auto-increment ones is.  overrides replaces the
+// default generator of individual columns.
+func newRowGenerator(db *sql.DB, table string, columns string, overrides map[string]GeneratorSpec) (*rowGenerator, error) {
+	described, err := describeTable(db, table)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(columns, ",") {
+		if name = strings.Trim(strings.TrimSpace(name), "`"); name != "" && name != "*" {
+			wanted[strings.ToLower(name)] = true
+		}
+	}
+	specs := make(map[string]GeneratorSpec)
+	for name, spec := range overrides {
+		specs[strings.ToLower(name)] = spec
+	}
+	for name := range specs {
+		found := false
+		for _, column := range described {
+			found = found || strings.ToLower(column.name) == name
+		}
+		if !found {
+			return nil, fmt.Errorf("generator for unknown column %s.%s", table, name)
+		}
+	}
+
+	gen := &rowGenerator{table: table}
+	for _, column := range described {
+		if len(wanted) > 0 && !wanted[strings.ToLower(column.name)] {
+			continue
+		}
+		spec, overridden := specs[strings.ToLower(column.name)]
+		if !overridden && len(wanted) == 0 && column.autoIncrement {
+			continue
+		}
+
+		generator, err := newValueGenerator(column, spec)
+		if err != nil {
+			return nil, err
+		}
+		if generator == nil {
+			var nextID int64 = 1
+			if column.unique && (isInteger(column) || isDecimal(column)) {
+				if err := db.QueryRow(fmt.Sprintf("SELECT COALESCE(FLOOR(MAX(`%s`)), 0) + 1 FROM `%s`", column.name, table)).Scan(&nextID); err != nil {
+					return nil, err
+				}
+			}
+			if generator, err = defaultGenerator(column, nextID); err != nil {
+				return nil, err
+			}
+			if spec.NullRatio > 0 {
+				generator = &nullableGenerator{ratio: spec.NullRatio, valueGenerator: generator}
+			}
+		}
+		gen.columns = append(gen.columns, column)
+		gen.generators = append(gen.generators, generator)
+	}
+	if len(gen.columns) == 0 {
+		return nil, fmt.Errorf("no columns to generate for table %s", table)
+	}
+	return gen, nil
+}
+
+// columnList returns the generated columns as a comma separated list.
+func (gen *rowGenerator) columnList() string {
+	names := make([]string, len(gen.columns))
+	for i, column := range gen.columns {
+		names[i] = fmt.Sprintf("`%s`", column.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// row returns a new random row.
+func (gen *rowGenerator) row(rng *rand.Rand) []interface{} {
+	values := make([]interface{}, len(gen.generators))
+	for i, generator := range gen.generators {
+		values[i] = generator.next(rng)
+	}
+	return values
+}
+
+// literalRow returns the column list and a new random row formatted as SQL
+// literals, ready to be used as an INSERT's VALUES.
+func (gen *rowGenerator) literalRow(rng *rand.Rand) (string, string) {
+	values := gen.row(rng)
+	literals := make([]string, len(values))
+	for i, value := range values {
+		literals[i] = sqlLiteral(value)
+	}
+	return gen.columnList(), strings.Join(literals, ", ")
+}
+
+// sqlLiteral formats a generated value as a SQL literal.
+func sqlLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\x00", `\0`).Replace(v) + "'"
+	default:
+		return sqlLiteral(fmt.Sprintf("%v", v))
+	}
+}
+
+// keyRange returns the key a key distribution takes its range from, see
+// GeneratorSpec.Key, and false if the spec sets its own range.  table is
+// the table of the step.
+func (spec *GeneratorSpec) keyRange(table string) (string, bool) {
+	if !keyDistributions[strings.ToLower(spec.Type)] {
+		return "", false
+	}
+	if spec.Key != "" {
+		return spec.Key, true
+	}
+	// Steps on several tables, like joins, have no single primary key.
+	if spec.Min == 0 && spec.Max == 0 && table != "" && !strings.ContainsAny(table, " ,") {
+		return table, true
+	}
+	return "", false
+}
+
+// discoverKeyRange returns the MIN and MAX of a key: "table.column", or
+// "table" for the first column of its primary key.
+func discoverKeyRange(db *sql.DB, key string) (int64, int64, error) {
+	table, column := key, ""
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		table, column = key[:i], key[i+1:]
+	}
+	if column == "" {
+		columns, err := describeTable(db, table)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, c := range columns {
+			if c.primary {
+				if !isInteger(c) {
+					return 0, 0, fmt.Errorf("key %s: the primary key column %s is not an integer", key, c.name)
+				}
+				column = c.name
+				break
+			}
+		}
+		if column == "" {
+			return 0, 0, fmt.Errorf("key %s: table %s has no primary key", key, table)
+		}
+	}
+
+	var min, max sql.NullInt64
+	query := fmt.Sprintf("SELECT MIN(`%s`), MAX(`%s`) FROM `%s`", column, column, table)
+	if err := db.QueryRow(query).Scan(&min, &max); err != nil {
+		return 0, 0, fmt.Errorf("key %s: %s", key, err.Error())
+	}
+	if !min.Valid || !max.Valid {
+		return 0, 0, fmt.Errorf("key %s: table %s is empty", key, table)
+	}
+	return min.Int64, max.Int64, nil
+}
+
+var rowGeneratorCache = struct {
+	sync.Mutex
+	generators map[string]*rowGenerator
+}{generators: make(map[string]*rowGenerator)}
+
+// cachedRowGenerator returns a generator with default settings for all
+// columns of table, introspecting the table only once.
+func cachedRowGenerator(db *sql.DB, table string) (*rowGenerator, error) {
+	rowGeneratorCache.Lock()
+	defer rowGeneratorCache.Unlock()
+	if gen, ok := rowGeneratorCache.generators[table]; ok {
+		return gen, nil
+	}
+	gen, err := newRowGenerator(db, table, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	rowGeneratorCache.generators[table] = gen
+	return gen, nil
+}
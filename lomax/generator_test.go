@@ -0,0 +1,226 @@
+package main
+
+import (
+	"math/rand"
+	"regexp"
+	"testing"
+)
+
+func TestDefaultGenerator(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	cases := []struct {
+		column  columnInfo
+		pattern string
+	}{
+		{columnInfo{name: "emp_no", dataType: "int", columnType: "int(11)"}, `^\d+$`},
+		{columnInfo{name: "flag", dataType: "tinyint", columnType: "tinyint(3) unsigned"}, `^\d{1,3}$`},
+		{columnInfo{name: "birth_date", dataType: "date", columnType: "date"}, `^'\d{4}-\d{2}-\d{2}'$`},
+		{columnInfo{name: "created", dataType: "datetime", columnType: "datetime"}, `^'\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}'$`},
+		{columnInfo{name: "gender", dataType: "enum", columnType: "enum('m','f')"}, `^'(m|f)'$`},
+		{columnInfo{name: "quote", dataType: "enum", columnType: "enum('it''s')"}, `^'it\\'s'$`},
+		{columnInfo{name: "dept_no", dataType: "char", columnType: "char(4)", maxLength: 4}, `^'[a-zA-Z0-9]{1,4}'$`},
+		{columnInfo{name: "price", dataType: "decimal", columnType: "decimal(6,2)", precision: 6, scale: 2}, `^'\d{1,4}\.\d{2}'$`},
+		{columnInfo{name: "hired", dataType: "year", columnType: "year(4)"}, `^\d{4}$`},
+	}
+	for _, c := range cases {
+		generator, err := defaultGenerator(c.column, 1)
+		if err != nil {
+			t.Errorf("%s: %s", c.column.columnType, err)
+			continue
+		}
+		for i := 0; i < 100; i++ {
+			literal := sqlLiteral(generator.next(rng))
+			if !regexp.MustCompile(c.pattern).MatchString(literal) {
+				t.Errorf("%s: generated %s, want %s", c.column.columnType, literal, c.pattern)
+				break
+			}
+		}
+	}
+}
+
+func TestUniqueIntegerDefaultsToSequence(t *testing.T) {
+	generator, _ := defaultGenerator(columnInfo{name: "id", dataType: "bigint", unique: true}, 42)
+	for want := int64(42); want < 45; want++ {
+		if got := generator.next(nil); got != want {
+			t.Errorf("unique column generated %v, want %d", got, want)
+		}
+	}
+}
+
+func TestMarkUnique(t *testing.T) {
+	columns := []columnInfo{{name: "emp_no", dataType: "int", primary: true}, {name: "salary", dataType: "int"},
+		{name: "from_date", dataType: "date", primary: true}, {name: "to_date", dataType: "date"}, {name: "Badge", dataType: "varchar"}}
+	markUnique(columns, map[string][]string{"PRIMARY": {"emp_no", "from_date"}, "badge": {"badge"}})
+	for _, column := range columns {
+		if want := column.name == "Badge"; column.unique != want {
+			t.Errorf("column %s unique = %t, want %t", column.name, column.unique, want)
+		}
+	}
+
+	// Every column of a composite key keeps its default generator.
+	for _, column := range columns[:4] {
+		if _, err := defaultGenerator(column, 1); err != nil {
+			t.Errorf("column %s of a composite primary key: %s", column.name, err)
+		}
+	}
+}
+
+func TestUniqueStringDefaults(t *testing.T) {
+	for _, maxLength := range []int64{2, 8, 255} {
+		generator, err := defaultGenerator(columnInfo{name: "email", dataType: "varchar", maxLength: maxLength, unique: true}, 1)
+		if err != nil {
+			t.Fatalf("varchar(%d): %s", maxLength, err)
+		}
+		seen := make(map[interface{}]bool)
+		for i := 0; i < 1000; i++ {
+			value := generator.next(nil)
+			if seen[value] && maxLength > 2 || int64(len(value.(string))) > maxLength {
+				t.Errorf("varchar(%d): generated %q twice or too long", maxLength, value)
+				break
+			}
+			seen[value] = true
+		}
+	}
+
+	for _, dataType := range []string{"date", "datetime", "timestamp", "enum"} {
+		if _, err := defaultGenerator(columnInfo{name: "created", dataType: dataType, unique: true}, 1); err == nil {
+			t.Errorf("unique %s column got a default generator", dataType)
+		}
+	}
+}
+
+func TestNewValueGenerator(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	column := columnInfo{name: "salary", dataType: "int"}
+	cases := []struct {
+		spec      GeneratorSpec
+		wantError bool
+	}{
+		{GeneratorSpec{Type: "sequence", Start: 10, Step: 5}, false},
+		{GeneratorSpec{Type: "uniform", Min: 10, Max: 20}, false},
+		{GeneratorSpec{Type: "uniform", Min: 20, Max: 10}, true},
+		{GeneratorSpec{Type: "zipfian", Min: 1, Max: 100, Skew: 1.5}, false},
+		{GeneratorSpec{Type: "zipfian", Min: 1, Max: 100, Skew: 0.5}, true},
+		{GeneratorSpec{Type: "latest", Min: 1, Max: 100}, false},
+		{GeneratorSpec{Type: "hotspot", Min: 1, Max: 100, HotKeys: 0.1, HotTraffic: 0.9}, false},
+		{GeneratorSpec{Type: "hotspot", Min: 1, Max: 100, HotKeys: 1.5}, true},
+		{GeneratorSpec{Type: "exponential", Min: 1, Max: 100, HotTraffic: 1}, true},
+		{GeneratorSpec{Type: "sequential", Min: 5, Max: 1}, true},
+		{GeneratorSpec{Type: "faker", Category: "first_name"}, false},
+		{GeneratorSpec{Type: "faker", Category: "horoscope"}, true},
+		{GeneratorSpec{Type: "set", Values: []interface{}{"a", float64(2)}}, false},
+		{GeneratorSpec{Type: "set"}, true},
+		{GeneratorSpec{Type: "gaussian"}, true},
+		{GeneratorSpec{Type: "uniform", Max: 1, NullRatio: 2}, true},
+	}
+	for _, c := range cases {
+		generator, err := newValueGenerator(column, c.spec)
+		if (err != nil) != c.wantError {
+			t.Errorf("newValueGenerator(%+v) returned error %v, want error %t", c.spec, err, c.wantError)
+			continue
+		}
+		if err == nil {
+			generator.next(rng)
+		}
+	}
+
+	uniform, _ := newValueGenerator(column, GeneratorSpec{Type: "uniform", Min: 10, Max: 20})
+	for i := 0; i < 100; i++ {
+		if v := uniform.next(rng).(int64); v < 10 || v > 20 {
+			t.Fatalf("uniform generated %d, want a value in [10, 20]", v)
+		}
+	}
+
+	nulls := 0
+	nullable, _ := newValueGenerator(column, GeneratorSpec{Type: "uniform", Max: 1, NullRatio: 0.5})
+	for i := 0; i < 1000; i++ {
+		if nullable.next(rng) == nil {
+			nulls++
+		}
+	}
+	if nulls < 400 || nulls > 600 {
+		t.Errorf("null_ratio 0.5 generated %d NULLs out of 1000", nulls)
+	}
+}
+
+func TestKeyDistributions(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	column := columnInfo{name: "emp_no", dataType: "int"}
+	// share returns the fraction of 10000 keys that fall within [from, to].
+	share := func(spec GeneratorSpec, from int64, to int64) float64 {
+		generator, err := newValueGenerator(column, spec)
+		if err != nil {
+			t.Fatalf("newValueGenerator(%+v) error = %s", spec, err.Error())
+		}
+		n := 0
+		for i := 0; i < 10000; i++ {
+			v := generator.next(rng).(int64)
+			if v < int64(spec.Min) || v > int64(spec.Max) {
+				t.Fatalf("%s generated %d, want a value in [%v, %v]", spec.Type, v, spec.Min, spec.Max)
+			}
+			if v >= from && v <= to {
+				n++
+			}
+		}
+		return float64(n) / 10000
+	}
+
+	if got := share(GeneratorSpec{Type: "hotspot", Min: 1, Max: 1000, HotKeys: 0.1, HotTraffic: 0.9}, 1, 100); got < 0.88 || got > 0.92 {
+		t.Errorf("hotspot sent %.3f of the keys to the hot 10%%, want 0.9", got)
+	}
+	if got := share(GeneratorSpec{Type: "exponential", Min: 1, Max: 1000, HotKeys: 0.1, HotTraffic: 0.9}, 1, 100); got < 0.88 || got > 0.92 {
+		t.Errorf("exponential sent %.3f of the keys to the first 10%%, want 0.9", got)
+	}
+	if got := share(GeneratorSpec{Type: "latest", Min: 1, Max: 1000, Skew: 1.5}, 991, 1000); got < 0.5 {
+		t.Errorf("latest sent %.3f of the keys to the last 10 keys, want most", got)
+	}
+	if got := share(GeneratorSpec{Type: "zipfian", Min: 1, Max: 1000, Skew: 1.5}, 1, 10); got < 0.5 {
+		t.Errorf("zipfian sent %.3f of the keys to the first 10 keys, want most", got)
+	}
+
+	sequential, _ := newValueGenerator(column, GeneratorSpec{Type: "sequential", Min: 3, Max: 5})
+	for i, want := range []int64{3, 4, 5, 3} {
+		if got := sequential.next(rng); got != want {
+			t.Errorf("sequential value %d = %v, want %d", i, got, want)
+		}
+	}
+}
+
+func TestKeyRange(t *testing.T) {
+	cases := []struct {
+		spec  GeneratorSpec
+		table string
+		want  string
+	}{
+		{GeneratorSpec{Type: "zipfian"}, "employees", "employees"},
+		{GeneratorSpec{Type: "hotspot", Key: "salaries.emp_no"}, "", "salaries.emp_no"},
+		{GeneratorSpec{Type: "uniform", Min: 10001, Max: 499999}, "employees", ""},
+		{GeneratorSpec{Type: "uniform"}, "employees a, salaries b", ""},
+		{GeneratorSpec{Type: "faker", Category: "email"}, "employees", ""},
+	}
+	for _, c := range cases {
+		key, ok := c.spec.keyRange(c.table)
+		if key != c.want || ok != (c.want != "") {
+			t.Errorf("keyRange(%+v, %q) = %q, %t, want %q", c.spec, c.table, key, ok, c.want)
+		}
+	}
+}
+
+func TestSQLLiteral(t *testing.T) {
+	cases := []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, "NULL"},
+		{int64(-3), "-3"},
+		{float64(2.5), "2.5"},
+		{"O'Brien", `'O\'Brien'`},
+		{`back\slash`, `'back\\slash'`},
+		{true, "'true'"},
+	}
+	for _, c := range cases {
+		if got := sqlLiteral(c.value); got != c.want {
+			t.Errorf("sqlLiteral(%v) = %s, want %s", c.value, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"math/bits"
+	"time"
+)
+
+// histogramSubBits is the number of bits of precision kept for every
+// recorded value.  64 sub-buckets per power of two keep the relative error
+// of any reported percentile below 1%.
+const histogramSubBits = 6
+
+// Histograms count latencies in microseconds, up to an hour.  Longer
+// latencies are counted in the last bucket, but still set the max.
+const (
+	histogramUnit = time.Microsecond
+	histogramMax  = time.Hour
+)
+
+const histogramSubBuckets = 1 << histogramSubBits
+
+// histogramBuckets is the number of buckets of every histogram, about 1700.
+var histogramBuckets = histogramIndex(histogramMax) + 1
+
+// latencyPercentiles are the percentiles reported for every operation.
+var latencyPercentiles = []float64{50, 90, 99, 99.9}
+
+// histogram is an HDR-style latency histogram.  Values are grouped into
+// log-linear buckets: exact below histogramSubBuckets microseconds, and with
+// histogramSubBits bits of precision above that.  Recording is O(1) and
+// allocation free, and histograms of different workers can be merged.
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, histogramBuckets)}
+}
+
+// histogramIndex returns the bucket a latency is counted in.
+func histogramIndex(d time.Duration) int {
+	if d > histogramMax {
+		d = histogramMax
+	}
+	value := uint64(0)
+	if d > 0 {
+		value = uint64(d / histogramUnit)
+	}
+	if value < histogramSubBuckets {
+		return int(value)
+	}
+	shift := uint(bits.Len64(value) - histogramSubBits - 1)
+	return int(shift+1)*histogramSubBuckets + int(value>>shift) - histogramSubBuckets
+}
+
+// histogramValue returns the midpoint of the latencies counted in a bucket.
+func histogramValue(index int) time.Duration {
+	if index < histogramSubBuckets {
+		return time.Duration(index)*histogramUnit + histogramUnit/2
+	}
+	shift := uint(index/histogramSubBuckets - 1)
+	lower := uint64(index%histogramSubBuckets+histogramSubBuckets) << shift
+	return time.Duration(lower)*histogramUnit + time.Duration(uint64(1)<<shift)*histogramUnit/2
+}
+
+func (h *histogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[histogramIndex(d)]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+}
+
+// merge adds all values recorded in other to h.
+func (h *histogram) merge(other *histogram) {
+	if other.count == 0 {
+		return
+	}
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.count += other.count
+	h.sum += other.sum
+}
+
+// subtract removes the values recorded in other, which must have been
+// recorded in h as well, from h.  The min and max of what remains are only
+// known to the precision of their buckets.
+func (h *histogram) subtract(other *histogram) {
+	if other.count == 0 {
+		return
+	}
+	h.count -= other.count
+	h.sum -= other.sum
+	h.min, h.max = 0, 0
+	first := true
+	for i := range h.counts {
+		h.counts[i] -= other.counts[i]
+		if h.counts[i] == 0 {
+			continue
+		}
+		if first {
+			h.min = histogramValue(i)
+			first = false
+		}
+		h.max = histogramValue(i)
+	}
+}
+
+// reset drops all recorded values.
+func (h *histogram) reset() {
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.count, h.sum, h.min, h.max = 0, 0, 0, 0
+}
+
+func (h *histogram) mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// percentile returns the value below which the given percentage of all
+// recorded values fall.
+func (h *histogram) percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(p/100*float64(h.count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			value := histogramValue(i)
+			if value < h.min {
+				return h.min
+			}
+			if value > h.max {
+				return h.max
+			}
+			return value
+		}
+	}
+	return h.max
+}
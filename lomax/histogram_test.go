@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHistogramIndex(t *testing.T) {
+	for _, value := range []time.Duration{time.Microsecond, 63 * time.Microsecond, 64 * time.Microsecond, 127 * time.Microsecond,
+		1000 * time.Microsecond, 123456789 * time.Nanosecond, time.Minute, histogramMax} {
+		index := histogramIndex(value)
+		if index < 0 || index >= histogramBuckets {
+			t.Fatalf("histogramIndex(%s) = %d, out of range", value, index)
+		}
+		got := histogramValue(index)
+		if diff := math.Abs(float64(got - value)); diff > float64(value)/100+float64(histogramUnit) {
+			t.Errorf("histogramValue(histogramIndex(%s)) = %s, off by more than 1%%", value, got)
+		}
+	}
+	if index := histogramIndex(-time.Second); index != 0 {
+		t.Errorf("histogramIndex(-1s) = %d, want 0", index)
+	}
+	if index := histogramIndex(math.MaxInt64); index != histogramBuckets-1 {
+		t.Errorf("histogramIndex(max) = %d, want the last bucket %d", index, histogramBuckets-1)
+	}
+	if histogramBuckets > 2000 {
+		t.Errorf("histograms have %d buckets, want at most 2000", histogramBuckets)
+	}
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	h := newHistogram()
+	for i := 1; i <= 10000; i++ {
+		h.record(time.Duration(i) * time.Microsecond)
+	}
+
+	cases := []struct {
+		percentile float64
+		want       time.Duration
+	}{
+		{50, 5000 * time.Microsecond},
+		{90, 9000 * time.Microsecond},
+		{99, 9900 * time.Microsecond},
+		{99.9, 9990 * time.Microsecond},
+		{100, 10000 * time.Microsecond},
+	}
+	for _, c := range cases {
+		got := h.percentile(c.percentile)
+		if diff := math.Abs(float64(got - c.want)); diff > float64(c.want)/100 {
+			t.Errorf("percentile(%v) = %s, want %s", c.percentile, got, c.want)
+		}
+	}
+	if h.min != time.Microsecond || h.max != 10000*time.Microsecond {
+		t.Errorf("min, max = %s, %s, want 1µs, 10ms", h.min, h.max)
+	}
+	if h.mean() != 5000500*time.Nanosecond {
+		t.Errorf("mean() = %s, want 5.0005ms", h.mean())
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, b := newHistogram(), newHistogram()
+	a.record(2 * time.Millisecond)
+	b.record(time.Millisecond)
+	b.record(3 * time.Millisecond)
+	a.merge(b)
+	a.merge(newHistogram())
+
+	if a.count != 3 || a.min != time.Millisecond || a.max != 3*time.Millisecond {
+		t.Errorf("merged histogram count, min, max = %d, %s, %s, want 3, 1ms, 3ms", a.count, a.min, a.max)
+	}
+	if got := a.percentile(50); got < 1980*time.Microsecond || got > 2020*time.Microsecond {
+		t.Errorf("merged percentile(50) = %s, want 2ms", got)
+	}
+}
+
+func TestHistogramSubtract(t *testing.T) {
+	a, b := newHistogram(), newHistogram()
+	a.record(time.Millisecond)
+	b.record(time.Millisecond)
+	a.merge(b)
+	a.record(5 * time.Millisecond)
+	a.subtract(b)
+
+	if a.count != 2 || a.sum != 6*time.Millisecond {
+		t.Errorf("subtracted histogram count, sum = %d, %s, want 2, 6ms", a.count, a.sum)
+	}
+	if a.min < 990*time.Microsecond || a.min > 1010*time.Microsecond || a.max < 4950*time.Microsecond || a.max > 5050*time.Microsecond {
+		t.Errorf("subtracted histogram min, max = %s, %s, want 1ms, 5ms", a.min, a.max)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+var intervalPtr time.Duration
+
+// intervalBuffer holds one time-series row per reported interval.
+var intervalBuffer [][]string
+var intervalHeader = append([]string{"Elapsed", "Queries", "Queries/sec", "Errors"}, latencyHeader...)
+
+func init() {
+	flag.DurationVar(&intervalPtr, "interval", 0, "Print and log throughput, errors and latency every interval: e.g. 10s. 0 disables interval reports.")
+}
+
+// intervalReporter periodically collects the statistics of the last
+// interval from a running worker pool, prints them and records them as a
+// time-series row.
+type intervalReporter struct {
+	pool *workerPool
+	// status samples the server status with every interval, see
+	// --intervalstatus.
+	status *statusProbe
+	every  time.Duration
+	start  time.Time
+	last   time.Time
+	// spare holds the cleared statistics that are swapped into the
+	// workers on the next collection.
+	spare [][]stepStats
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// startIntervalReporter starts reporting on pool every interval.  It returns
+// nil, and reports nothing, if interval is not positive.  status may be nil.
+func startIntervalReporter(pool *workerPool, interval time.Duration, status *statusProbe) *intervalReporter {
+	if interval <= 0 {
+		return nil
+	}
+
+	reporter := &intervalReporter{
+		pool:   pool,
+		status: status,
+		every:  interval,
+		start:  time.Now(),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	reporter.last = reporter.start
+	for _, w := range pool.workers {
+		reporter.spare = append(reporter.spare, newStepStats(len(w.interval)))
+	}
+	// Drop anything recorded before the reporter started.
+	reporter.spare = pool.collectInterval(reporter.spare)
+	reporter.clearSpare()
+
+	go reporter.loop()
+	return reporter
+}
+
+func (reporter *intervalReporter) loop() {
+	defer close(reporter.done)
+	ticker := time.NewTicker(reporter.every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reporter.report()
+		case <-reporter.stop:
+			reporter.report()
+			return
+		}
+	}
+}
+
+// Stop reports the final, possibly partial, interval and stops the reporter.
+// It is safe to call on a nil reporter.
+func (reporter *intervalReporter) Stop() {
+	if reporter == nil {
+		return
+	}
+	close(reporter.stop)
+	<-reporter.done
+}
+
+func (reporter *intervalReporter) clearSpare() {
+	for _, stats := range reporter.spare {
+		for i := range stats {
+			stats[i].clear()
+		}
+	}
+}
+
+func (reporter *intervalReporter) report() {
+	now := time.Now()
+	reporter.spare = reporter.pool.collectInterval(reporter.spare)
+
+	total := newStepStats(1)[0]
+	for _, stats := range reporter.spare {
+		for i := range stats {
+			total.add(&stats[i])
+		}
+	}
+	reporter.clearSpare()
+
+	length := now.Sub(reporter.last)
+	reporter.last = now
+	if length <= 0 {
+		return
+	}
+
+	elapsed := now.Sub(reporter.start).Truncate(time.Millisecond)
+	rate := float64(total.iterations) / length.Seconds()
+	fmt.Println(fmt.Sprintf("[%8s] %10.1f queries/sec, %d errors, p50 %s, p90 %s, p99 %s, p99.9 %s, max %s", elapsed, rate, total.errors,
+		total.latency.percentile(50), total.latency.percentile(90), total.latency.percentile(99), total.latency.percentile(99.9), total.latency.max))
+
+	row := []string{fmt.Sprintf("%s", elapsed), fmt.Sprintf("%d", total.iterations), fmt.Sprintf("%.1f", rate), fmt.Sprintf("%d", total.errors)}
+	intervalBuffer = append(intervalBuffer, append(row, latencyColumns(total.latency)...))
+	resultDoc.Intervals = append(resultDoc.Intervals, intervalRecord{ElapsedSeconds: elapsed.Seconds(), Queries: total.iterations, QueriesPerSecond: rate, Errors: total.errors, LatencyMs: newLatencyRecord(total.latency)})
+	if intervalStatusPtr {
+		reporter.status.sample(elapsed)
+	}
+}
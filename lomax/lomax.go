@@ -4,25 +4,24 @@
 //
 // Example:
 //
-//	   ./lomax --vector=openstack-generic-test-select.json --config=openstack-generic-config.json
+//	   ./lomax --db=employees --operation=SELECT --table=departments --cols="*" --threads=100 --count=10000 --config=openstack-generic-config.json
 //
-// 	+--------------------------------+--------------+------------+-----------+-----------+
-//	|            FUNCTION            |  TIME TAKEN  | ITERATIONS | MEMALLOCS | MEMBYTES  |
-//	+--------------------------------+--------------+------------+-----------+-----------+
-//	| main.BenchmarkInitializeDB     | 3.03814889s  |     200000 |   2009863 | 151017520 |
-//	| main.BenchmarkPrepareStatement | 3.081639984s |      10000 |    262477 |  10824768 |
-//	| main.BenchmarkProcessData      | 1.262388201s |  100000000 |        96 |      8224 |
-//	+--------------------------------+--------------+------------+-----------+-----------+
+// 	+--------------------------------+--------------+------------+-----------+-----------+---------+-----------+--------+--------+---------+--------+---------+---------+--------+----------+
+//	|            FUNCTION            |  TIME TAKEN  | ITERATIONS | MEMALLOCS | MEMBYTES  |  ROWS   |   BYTES   | ERRORS |  MIN   |  MEAN   |  P50   |   P90   |   P99   | P99 9  |   MAX    |
+//	+--------------------------------+--------------+------------+-----------+-----------+---------+-----------+--------+--------+---------+--------+---------+---------+--------+----------+
+//	| main.BenchmarkInitializeDB     | 3.03814889s  |     200000 |   2009863 | 151017520 | -       | -         | -      | -      | -       | -      | -       | -       | -      | -        |
+//	| main.BenchmarkPrepareStatement | 3.081639984s |      10000 |    262477 |  10824768 | -       | -         | -      | -      | -       | -      | -       | -       | -      | -        |
+//	| main.workerPool[100] SELECT    | 1.262388201s |    1000000 |   4200096 | 268408224 | 9000000 | 261000000 |      0 | 41.2µs | 125.1µs | 98.5µs | 201.7µs | 512.5µs | 2.1ms  | 12.406ms |
+//	+--------------------------------+--------------+------------+-----------+-----------+---------+-----------+--------+--------+---------+--------+---------+---------+--------+----------+
 
 package main
 
 import (
 	"database/sql"
 	"encoding/csv"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"math/rand"
 	"os"
 	"reflect"
 	"runtime"
@@ -31,7 +30,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/enodata/faker"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/olekukonko/tablewriter"
 	"github.com/opendns/lemming/lib/log"
@@ -43,30 +41,43 @@ var USER string
 // PASSWORD : The MySQL user's password, passed in through the config file
 var PASSWORD string
 
-// This schema is only valid for datacharmer/test_db
-// If you would like to use your own, please change accordingly.
-var (
-	deptNo    string
-	deptName  string
-	empNo     int
-	fromDate  string
-	toDate    string
-	birthDate string
-	firstName string
-	lastName  string
-	gender    string
-	hireDate  string
-	salary    int
-	title     string
-)
+// rowBuffer holds the scan targets of processData.  Every worker owns one so
+// that concurrent workers never share them, and it is reused for as long as
+// the result sets keep the same number of columns.
+type rowBuffer struct {
+	columns []*sql.ColumnType
+	values  []sql.RawBytes
+	dest    []interface{}
+	// rows and bytes count what the last call to processData read.
+	rows  int64
+	bytes int64
+}
+
+// prepare sizes the scan targets for the given result set columns.
+func (buf *rowBuffer) prepare(columns []*sql.ColumnType) {
+	buf.columns = columns
+	if len(buf.values) == len(columns) {
+		return
+	}
+	buf.values = make([]sql.RawBytes, len(columns))
+	buf.dest = make([]interface{}, len(columns))
+	for i := range buf.values {
+		buf.dest[i] = &buf.values[i]
+	}
+}
 
 // Make stuff that is common globally accessible
-var operationPtr, flagPtr, randomPtr, columnsPtr, hostNamePtr, portPtr, dbPtr, tablePtr, conditionPtr string
+var operationPtr, flagPtr, randomPtr, columnsPtr, hostNamePtr, portPtr, dbPtr, tablePtr, conditionPtr, paramsPtr string
 var logType, logPrefix string
-var config map[string]interface{}
 var benchmarkData []string
 var benchBuffer [][]string
+var benchHeader = append([]string{"Function", "Time Taken", "Iterations", "MemAllocs", "MemBytes"}, statsHeader...)
+var statsHeader = append([]string{"Rows", "Bytes", "Errors"}, latencyHeader...)
+var latencyHeader = []string{"Min", "Mean", "P50", "P90", "P99", "P99.9", "Max"}
 var threadPtr, countPtr float64
+var durationPtr time.Duration
+var ratePtr float64
+var preparedPtr bool
 
 var jsonConfig, testVectorConfig string
 
@@ -85,370 +96,250 @@ func init() {
 	flag.StringVar(&dbPtr, "db", "", "DB to perform queries on.")
 	flag.StringVar(&tablePtr, "table", "", "Table to use for operations.")
 	flag.StringVar(&conditionPtr, "condition", "", "Any conditions to enforce on query.")
+	flag.StringVar(&paramsPtr, "params", "", `Generators for the ? placeholders of --condition, as a JSON list: e.g. '[{"type": "zipfian", "skew": 1.2}]'. Key ranges default to the primary key of --table.`)
 	flag.Float64Var(&countPtr, "count", 1, "Number of iterations to perform.")
+	flag.DurationVar(&durationPtr, "duration", 0, "Run for a wall clock period instead of --count iterations: e.g. 30s, 5m.")
+	flag.BoolVar(&preparedPtr, "prepared", true, "Use server-side prepared statements. false sends queries over the text protocol with client-side interpolated parameters.")
+	flag.Float64Var(&ratePtr, "rate", 0, "Target queries per second over all threads (open-loop). 0 runs as fast as possible.")
 	flag.StringVar(&USER, "user", "", "MySQL username.")
 	flag.StringVar(&PASSWORD, "password", "", "MySQL password.")
 }
 
-func setPtrs() {
-	configParse()
-	if jsonConfig != "" {
-		USER = config["user"].(string)
-		PASSWORD = config["password"].(string)
-		hostNamePtr = config["hostname"].(string)
-		portPtr = config["port"].(string)
-		dbPtr = config["db"].(string)
-		threadPtr = config["threads"].(float64)
-	}
-
-	if testVectorConfig != "" {
-		USER = config["user"].(string)
-		PASSWORD = config["password"].(string)
-		operationPtr = config["action"].(string)
-		flagPtr = config["flag"].(string)
-		columnsPtr = config["columns"].(string)
-		dbPtr = config["db"].(string)
-		tablePtr = config["table"].(string)
-		conditionPtr = config["condition"].(string)
-		countPtr = config["count"].(float64)
-	}
-}
-
 // GetFunctionName : Returns the name of the passed function
 func GetFunctionName(i interface{}) string {
 	return runtime.FuncForPC(reflect.ValueOf(i).Pointer()).Name()
 }
 
 // BenchmarkInitializeDB : Benchmark helper function to benchmark initializeDB()
+// sql.Open does not dial, so every iteration pings to force a handshake.
 func BenchmarkInitializeDB(bench *testing.B) {
 	for iter := 0; iter < bench.N*int(countPtr); iter++ {
 		db := initializeDB()
+		if err := db.Ping(); err != nil {
+			log.Error(err.Error())
+		}
 		db.Close()
 	}
 }
 
+// prepareStatementStep names BenchmarkPrepareStatement in errors.  It lives
+// across the runs testing.Benchmark makes, so every error class is only
+// logged once.
+var prepareStatementStep = WorkloadStep{Name: "BenchmarkPrepareStatement"}
+
 // BenchmarkPrepareStatement : Benchmark helper function to benchmark prepareStatement()
+// Failures are handled according to --onerror, like those of the workers.
 func BenchmarkPrepareStatement(bench *testing.B) {
 	db := initializeDB()
 	defer db.Close()
 
+	w := newWorker(0, db, 1)
+
 	for iter := 0; iter < bench.N*int(countPtr); iter++ {
-		rows := prepareStatement(db, operationPtr, flagPtr, randomPtr, columnsPtr, tablePtr, conditionPtr)
-		if rows != nil {
-			rows.Close()
+		for attempt := 0; ; attempt++ {
+			rows, err := prepareStatement(db, operationPtr, flagPtr, randomPtr, columnsPtr, tablePtr, conditionPtr)
+			if err == nil {
+				if rows != nil {
+					rows.Close()
+				}
+				break
+			}
+			if !w.retryError(&prepareStatementStep, err, attempt) {
+				break
+			}
 		}
 	}
 }
 
-// BenchmarkProcessData : Benchmark helper function to benchmark processData()
-func BenchmarkProcessData(bench *testing.B) {
-	db := initializeDB()
-	defer db.Close()
-
-	rows := prepareStatement(db, operationPtr, flagPtr, randomPtr, columnsPtr, tablePtr, conditionPtr)
-	if rows != nil {
-		defer rows.Close()
-		for iter := 0; iter < bench.N*int(countPtr); iter++ {
-			_ = processData(rows, columnsPtr, tablePtr)
-		}
+// validateInput checks the merged configuration and reports every problem
+// it finds at once.
+func validateInput() {
+	problems := configProblems()
+	if len(problems) > 0 {
+		log.Error("Invalid configuration:\n  %s", strings.Join(problems, "\n  "))
 	}
 }
 
-func configParse(inputFile ...string) {
-
-	if inputFile != nil {
-		file, err := ioutil.ReadFile(fmt.Sprintf("./lib/%s", inputFile[0]))
-		if err != nil {
-			log.Error(fmt.Sprintf("File IO Error: %s\n", err.Error()))
-		}
+// configProblems returns the problems of the merged configuration.
+func configProblems() []string {
+	var problems []string
+	if durationPtr < 0 {
+		problems = append(problems, "Please specify a positive --duration.")
+	}
+	if ratePtr < 0 {
+		problems = append(problems, "Please specify a positive --rate.")
+	}
+	if threadPtr < 1 {
+		problems = append(problems, "Please specify at least one thread using the --threads option.")
+	}
+	if !validOnError(onErrorPtr) {
+		problems = append(problems, fmt.Sprintf("Please specify --onerror=%s, %s or %s.", onErrorAbort, onErrorContinue, onErrorRetry))
+	}
+	problems = append(problems, validateConnection()...)
+	problems = append(problems, validateTargets()...)
+	problems = append(problems, validateSplit()...)
+	problems = append(problems, validateServerStatus()...)
+	problems = append(problems, validateReplay()...)
+
+	if hostNamePtr == "" && !hasAddress() {
+		problems = append(problems, "Please specify a hostname using the --hostname option.")
+	}
+	if portPtr == "" && !hasAddress() {
+		problems = append(problems, "Please specify the port number using the --port option.")
+	}
 
-		if err := json.Unmarshal(file, &config); err != nil {
-			log.Error(err.Error())
+	if workloadFile != "" || testVectorConfig != "" || replayFile != "" {
+		if dbPtr == "" && workloadFile != "" {
+			problems = append(problems, "Please specify a MySQL database using the --db option or the \"db\" key of the workload.")
 		}
-		USER = config["user"].(string)
-		PASSWORD = config["password"].(string)
-	} else {
-		if jsonConfig != "" {
-			file, err := ioutil.ReadFile(fmt.Sprintf("./lib/%s", jsonConfig))
-			if err != nil {
-				log.Error(fmt.Sprintf("File IO Error: %s\n", err.Error()))
-			}
-			json.Unmarshal(file, &config)
+		if USER == "" && dsnPtr == "" {
+			problems = append(problems, "Please specify a MySQL user using the --user option.")
 		}
+		return problems
+	}
 
-		if testVectorConfig != "" {
-			fileTestConfig, errTestConfig := ioutil.ReadFile(fmt.Sprintf("./testvectors/%s", testVectorConfig))
-			if errTestConfig != nil {
-				log.Error(fmt.Sprintf("Test config File IO Error: %s\n", errTestConfig.Error()))
-			}
-			json.Unmarshal(fileTestConfig, &config)
+	if isConnect() {
+		if USER == "" && dsnPtr == "" {
+			problems = append(problems, "Please specify a MySQL user using the --user option.")
+		}
+		if stormPtr < 0 {
+			problems = append(problems, "Please specify a positive --storm.")
 		}
+		return problems
 	}
-}
 
-func validateInput() {
-	if tablePtr == "" && testVectorConfig == "" {
-		log.Error("Please specify a MySQL table using the --table option.")
-	} else if hostNamePtr == "" && testVectorConfig == "" {
-		log.Error("Please specify a hostname using the --hostname option.")
-	} else if portPtr == "" && testVectorConfig == "" {
-		log.Error("Please specify the port number using the --port option.")
-	} else if dbPtr == "" && testVectorConfig == "" {
-		log.Error("Please specify a MySQL database using the --database option.")
-	} else if operationPtr == "" && testVectorConfig == "" {
-		log.Error("Please specify a MySQL operation using the --operation option or specify a test vector using --vector option.")
-	} else if columnsPtr == "" && testVectorConfig == "" && randomPtr == "" {
-		if operationPtr != "UPDATE" && operationPtr != "DELETE" {
-			log.Error("Please specify columns to operate on using the --cols option or specify a test vector using the --vector option.")
+	if _, err := benchmarkParams(); err != nil {
+		problems = append(problems, fmt.Sprintf("Please check the --params option: %s", err.Error()))
+	}
+	if bulkPtr != "" || batchPtr != 0 {
+		step := WorkloadStep{Action: operationPtr, Bulk: bulkPtr, Batch: batchPtr}
+		if err := step.validateBulk("--bulk"); err != nil {
+			problems = append(problems, fmt.Sprintf("Please check the --bulk and --batch options: %s", err.Error()))
 		}
-	} else if USER == "" && jsonConfig == "" {
-		log.Error("Please specify a MySQL user using the --user option.")
-	} else if PASSWORD == "" && jsonConfig == "" {
-		log.Error("Please specify the MySQL password for the user using the --password option.")
 	}
+	if tablePtr == "" {
+		problems = append(problems, "Please specify a MySQL table using the --table option.")
+	}
+	if dbPtr == "" && dsnPtr == "" {
+		problems = append(problems, "Please specify a MySQL database using the --database option.")
+	}
+	if operationPtr == "" {
+		problems = append(problems, "Please specify a MySQL operation using the --operation option or specify a test vector using --vector option.")
+	} else if columnsPtr == "" && randomPtr == "" && bulkPtr == "" && operationPtr != "UPDATE" && operationPtr != "DELETE" {
+		problems = append(problems, "Please specify columns to operate on using the --cols option or specify a test vector using the --vector option.")
+	}
+	if USER == "" && dsnPtr == "" {
+		problems = append(problems, "Please specify a MySQL user using the --user option.")
+	}
+	if PASSWORD == "" && dsnPtr == "" {
+		problems = append(problems, "Please specify the MySQL password for the user using the --password option.")
+	}
+	return problems
 }
 
 func initializeDB(inputParams ...string) *sql.DB {
-	// lomax_test.go uses custom command function name for testing purposes only
+	// lomax_test.go and the test vector runner pass explicit connection parameters
 	if len(inputParams) != 0 {
-		db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", inputParams[0], inputParams[1], inputParams[2], inputParams[3], inputParams[4]))
-		if err != nil {
-			log.Error(err.Error())
-		}
-		return db
+		return initializeSessionDB(nil, inputParams[0], inputParams[1], inputParams[2], inputParams[3], inputParams[4])
 	}
 
-	db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", USER, PASSWORD, hostNamePtr, portPtr, dbPtr))
+	dsn, err := buildDSN(USER, PASSWORD, hostNamePtr, portPtr, dbPtr)
 	if err != nil {
 		log.Error(err.Error())
 	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		log.Error(err.Error())
+	}
+	configurePool(db)
 	return db
 }
 
-func prepareStatement(db *sql.DB, operationPtr string, flagPtr string, randomPtr string, columnsPtr string, tablePtr string, conditionPtr string) *sql.Rows {
-	if randomPtr == "true" {
-		switch tablePtr {
-		case "employees":
-			columnsPtr = fmt.Sprintf("emp_no, birth_date, first_name, last_name, gender, hire_date")
-			conditionPtr = fmt.Sprintf("%s, %s, '%s', '%s', '%s', %s", faker.Number().Number(6), faker.Date().Birthday(10, 40).Format("2006-01-02"), strings.Replace(faker.Name().FirstName(), "'", "", -1), strings.Replace(faker.Name().LastName(), "'", "", -1), "F", faker.Date().Forward(0).Format("2006-01-02"))
-		case "dept_emp":
-			columnsPtr = fmt.Sprintf("emp_no, dept_no, from_date, to_date")
-			conditionPtr = fmt.Sprintf("%s, %s, '%s', '%s'", faker.Number().Number(6), faker.Date().Birthday(10, 40).Format("2006-01-02"), faker.Date().Forward(0).Format("2006-01-02"))
-		case "salaries":
-			columnsPtr = fmt.Sprintf("emp_no, salary, from_date, to_date")
-			conditionPtr = fmt.Sprintf("%s, %s, '%s', '%s'", faker.Number().Number(6), faker.Number().Number(6), faker.Date().Birthday(10, 40).Format("2006-01-02"), faker.Date().Forward(0).Format("2006-01-02"))
-		case "titles":
-			columnsPtr = fmt.Sprintf("emp_no, title, from_date, to_date")
-			conditionPtr = fmt.Sprintf("%s, %s, '%s', '%s'", faker.Number().Number(6), faker.Name().Title(), faker.Date().Birthday(10, 40).Format("2006-01-02"), faker.Date().Forward(0).Format("2006-01-02"))
-		case "dept_manager":
-			columnsPtr = fmt.Sprintf("emp_no, dept_no, from_date, to_date")
-			conditionPtr = fmt.Sprintf("%s, %s, '%s', '%s'", faker.Number().Number(6), faker.Number().Number(4), faker.Date().Birthday(10, 40).Format("2006-01-02"), faker.Date().Forward(0).Format("2006-01-02"))
-		case "departments":
-			columnsPtr = fmt.Sprintf("dept_no, dept_name")
-			conditionPtr = fmt.Sprintf("%s, '%s'", faker.Number().Number(4), strings.Replace(faker.Team().Name(), "'", "", -1))
-		default:
-			log.Error("[%s]: Invalid SQL table specified. Please check the --table option.", GetFunctionName(prepareStatement))
-		}
+// initializeSessionDB opens a connection pool whose connections set the
+// session variables of session on top of those of --session.
+func initializeSessionDB(session map[string]string, user string, password string, host string, port string, dbName string) *sql.DB {
+	dsn, err := buildDSN(user, password, host, port, dbName)
+	if err == nil && len(session) > 0 {
+		dsn, err = sessionDSN(dsn, session)
+	}
+	if err != nil {
+		log.Error(err.Error())
 	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		log.Error(err.Error())
+	}
+	configurePool(db)
+	return db
+}
 
-	switch strings.ToUpper(operationPtr) {
-	case "SELECT":
-		stmtOut, err := db.Prepare(fmt.Sprintf("%s %s %s FROM %s %s", operationPtr, flagPtr, columnsPtr, tablePtr, conditionPtr))
-		if err != nil {
-			log.Warning(fmt.Sprintf("%s %s %s FROM %s %s", operationPtr, flagPtr, columnsPtr, tablePtr, conditionPtr))
-			log.Error(err.Error())
-		}
-		rows, err := stmtOut.Query()
-		if err != nil {
-			log.Error(err.Error())
-		}
-		defer stmtOut.Close()
-		return rows
-	case "INSERT":
-		stmtOut, err := db.Prepare(fmt.Sprintf("%s %s INTO %s (%s) VALUES (%s)", operationPtr, flagPtr, tablePtr, columnsPtr, conditionPtr))
-		if err != nil {
-			log.Warning(fmt.Sprintf("%s %s INTO %s (%s) VALUES (%s)", operationPtr, flagPtr, tablePtr, columnsPtr, conditionPtr))
-			log.Error(err.Error())
-		}
-		_, err = stmtOut.Exec()
-		if err != nil {
-			log.Error(err.Error())
-		}
-		defer stmtOut.Close()
-		return nil
-	case "DELETE":
-		stmtOut, err := db.Prepare(fmt.Sprintf("%s %s FROM %s WHERE %s", operationPtr, flagPtr, tablePtr, conditionPtr))
-		if err != nil {
-			log.Warning(fmt.Sprintf("%s %s FROM %s WHERE %s", operationPtr, flagPtr, tablePtr, conditionPtr))
-			log.Error(err.Error())
-		}
-		_, err = stmtOut.Exec()
-		if err != nil {
-			log.Error(err.Error())
-		}
-		defer stmtOut.Close()
-		return nil
-	case "UPDATE":
-		stmtOut, err := db.Prepare(fmt.Sprintf("%s %s %s SET %s", operationPtr, flagPtr, tablePtr, conditionPtr))
-		if err != nil {
-			log.Warning(fmt.Sprintf("%s %s %s SET %s", operationPtr, flagPtr, tablePtr, conditionPtr))
-			log.Error(err.Error())
-		}
-		_, err = stmtOut.Exec()
+// prepareStatement prepares and runs a single statement built from the
+// options.  It returns the result set of a SELECT, and nil for the other
+// operations.
+func prepareStatement(db *sql.DB, operationPtr string, flagPtr string, randomPtr string, columnsPtr string, tablePtr string, conditionPtr string) (*sql.Rows, error) {
+	if randomPtr == "true" {
+		gen, err := cachedRowGenerator(db, tablePtr)
 		if err != nil {
-			log.Error(err.Error())
+			return nil, fmt.Errorf("%s. Please check the --table option", err.Error())
 		}
-		defer stmtOut.Close()
-		return nil
-	default:
-		log.Error("[%s]: Invalid SQL operation specified. Please check the --operation option.", GetFunctionName(prepareStatement))
+		columnsPtr, conditionPtr = gen.literalRow(rand.New(rand.NewSource(time.Now().UnixNano())))
 	}
 
-	// should not reach here.
-	return nil
-}
+	query := buildQuery(operationPtr, flagPtr, columnsPtr, tablePtr, conditionPtr)
+	if query == "" {
+		return nil, fmt.Errorf("invalid SQL operation specified. Please check the --operation option")
+	}
+
+	stmtOut, err := db.Prepare(query)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", query, err.Error())
+	}
+	defer stmtOut.Close()
 
-func determineTables(tables string) []string {
-	strArr := strings.Split(tables, " ")
-	var tablesArr []string
-	for i := 0; i < len(strArr); i += 2 {
-		tablesArr = append(tablesArr, strArr[i])
+	if strings.ToUpper(operationPtr) == "SELECT" {
+		return stmtOut.Query()
 	}
-	return tablesArr
+	_, err = stmtOut.Exec()
+	return nil, err
 }
 
-func determineColumns(columns string) []string {
-	strArr := strings.FieldsFunc(columns, func(r rune) bool { return r == '.' || r == ',' })
-	var colsArr []string
-	for i := 1; i < len(strArr); i += 2 {
-		colsArr = append(colsArr, strArr[i])
+// buildQuery assembles the SQL statement for an operation.  It returns an
+// empty string for unknown operations.
+func buildQuery(operation string, flag string, columns string, table string, condition string) string {
+	switch strings.ToUpper(operation) {
+	case "SELECT":
+		return fmt.Sprintf("%s %s %s FROM %s %s", operation, flag, columns, table, condition)
+	case "INSERT":
+		return fmt.Sprintf("%s %s INTO %s (%s) VALUES (%s)", operation, flag, table, columns, condition)
+	case "DELETE":
+		return fmt.Sprintf("%s %s FROM %s WHERE %s", operation, flag, table, condition)
+	case "UPDATE":
+		return fmt.Sprintf("%s %s %s SET %s", operation, flag, table, condition)
 	}
-	return colsArr
+	return ""
 }
 
-func processData(rows *sql.Rows, columns string, tables string) bool {
-	tablesArr := determineTables(tables)
-	//columnsArr := determineColumns(columns)
+// processData drains rows into buf, whatever the schema of the result set,
+// counting the rows and bytes returned.
+func processData(buf *rowBuffer, rows *sql.Rows) error {
+	buf.rows, buf.bytes = 0, 0
 
-	for rows.Next() {
-		switch tablesArr[0] {
-		case "employees":
-			if len(tablesArr) == 1 { // singular table operation
-				err := rows.Scan(&empNo, &birthDate, &firstName, &lastName, &gender, &hireDate)
-				defer rows.Close()
-				if err != nil {
-					log.Error(err.Error())
-				}
-			} else if tablesArr[1] == "salaries" { // JOIN between employees and salaries
-				err := rows.Scan(&empNo, &birthDate, &firstName, &lastName, &gender, &hireDate, &salary, &fromDate, &toDate)
-				defer rows.Close()
-				if err != nil {
-					log.Error(err.Error())
-				}
-			} else if tablesArr[1] == "dept_emp" { // JOIN between employees and dept_emp
-				err := rows.Scan(&empNo, &birthDate, &firstName, &lastName, &gender, &hireDate, &deptNo, &fromDate, &toDate)
-				defer rows.Close()
-				if err != nil {
-					log.Error(err.Error())
-				}
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	buf.prepare(columns)
 
-			} else if tablesArr[1] == "dept_manager" { // JOIN between employees and dept_manager
-				err := rows.Scan(&empNo, &birthDate, &firstName, &lastName, &gender, &hireDate, &deptNo, &fromDate, &toDate)
-				defer rows.Close()
-				if err != nil {
-					log.Error(err.Error())
-				}
-			} else if tablesArr[1] == "titles" { // JOIN between employees and titles
-				err := rows.Scan(&empNo, &birthDate, &firstName, &lastName, &gender, &hireDate, &title, &fromDate, &toDate)
-				defer rows.Close()
-				if err != nil {
-					log.Error(err.Error())
-				}
-			}
-		case "departments":
-			if len(tablesArr) == 1 { // singular table operation
-				err := rows.Scan(&deptNo, &deptName)
-				defer rows.Close()
-				if err != nil {
-					log.Error(err.Error())
-				}
-			} else if tablesArr[1] == "dept_manager" { // JOIN between departments and dept_manager
-				err := rows.Scan(&deptNo, &deptName, &empNo, &fromDate, &toDate)
-				defer rows.Close()
-				if err != nil {
-					log.Error(err.Error())
-				}
-			} else if tablesArr[1] == "dept_emp" { // JOIN between departments and dept_emp
-				err := rows.Scan(&deptNo, &deptName, &empNo, &fromDate, &toDate)
-				defer rows.Close()
-				if err != nil {
-					log.Error(err.Error())
-				}
-			}
-		case "dept_emp":
-			if len(tablesArr) == 1 { // singular table operation
-				err := rows.Scan(&empNo, &deptNo, &fromDate, &toDate)
-				defer rows.Close()
-				if err != nil {
-					log.Error(err.Error())
-				}
-			} else if tablesArr[1] == "employees" { // JOIN between dept_emp and employees
-				err := rows.Scan(&empNo, &deptNo, &fromDate, &toDate, &birthDate, &firstName, &lastName, &gender, &hireDate)
-				defer rows.Close()
-				if err != nil {
-					log.Error(err.Error())
-				}
-			} else if tablesArr[1] == "departments" { // JOIN between dept_emp and departments
-				err := rows.Scan(&empNo, &deptNo, &fromDate, &toDate, &deptName)
-				defer rows.Close()
-				if err != nil {
-					log.Error(err.Error())
-				}
-			}
-		case "salaries":
-			if len(tablesArr) == 1 { // singular table operation
-				err := rows.Scan(&empNo, &salary, &fromDate, &toDate)
-				defer rows.Close()
-				if err != nil {
-					log.Error(err.Error())
-				}
-			} else if tablesArr[1] == "employees" { // JOIN between salaries and employees
-				err := rows.Scan(&empNo, &salary, &fromDate, &toDate, &birthDate, &firstName, &lastName, &gender, &hireDate)
-				defer rows.Close()
-				if err != nil {
-					log.Error(err.Error())
-				}
-			}
-		case "titles":
-			if len(tablesArr) == 1 { // singular table operation
-				err := rows.Scan(&empNo, &title, &fromDate, &toDate)
-				defer rows.Close()
-				if err != nil {
-					log.Error(err.Error())
-				}
-			} else if tablesArr[1] == "employees" { // JOIN between title and employees
-				err := rows.Scan(&empNo, &title, &fromDate, &toDate, &birthDate, &firstName, &lastName, &gender, &hireDate)
-				defer rows.Close()
-				if err != nil {
-					log.Error(err.Error())
-				}
-			}
-		default:
-			log.Error("Invalid table specified, please check the --table option.")
-			return false
+	for rows.Next() {
+		if err := rows.Scan(buf.dest...); err != nil {
+			return err
 		}
-		err := rows.Err()
-		defer rows.Close()
-		if err != nil {
-			log.Error(err.Error())
-			return false
+		buf.rows++
+		for _, value := range buf.values {
+			buf.bytes += int64(len(value))
 		}
 	}
-	// Only reaches here if rows is empty.
-	if rows != nil {
-		return true
-	}
-	return false
+	return rows.Err()
 }
 
 func runBenchmarks() {
@@ -456,23 +347,83 @@ func runBenchmarks() {
 
 	sqlQuery := fmt.Sprintf("%s %s %s %s %s", operationPtr, flagPtr, columnsPtr, tablePtr, conditionPtr)
 	fmt.Println(fmt.Sprintf("[%s]: Running Query: %s", GetFunctionName(runBenchmarks), sqlQuery))
+	params, _ := benchmarkParams()
 
 	if logPrefix == "" {
 		log.Warning(fmt.Sprintf("[%s]: No --logprefix defined, log file will NOT be created", GetFunctionName(exportData)))
 	}
 
-	br := testing.Benchmark(BenchmarkInitializeDB)
-	collectData(br, BenchmarkInitializeDB)
+	forEachTransport(func(suffix string) {
+		// The testing package picks its own number of iterations, so these
+		// are skipped when the run has to take a fixed amount of time.  They
+		// bind no --params and insert single rows.
+		if durationPtr == 0 && paramsPtr == "" && bulkPtr == "" && batchPtr == 0 {
+			br := testing.Benchmark(BenchmarkInitializeDB)
+			collectData(br, BenchmarkInitializeDB, suffix)
 
-	br = testing.Benchmark(BenchmarkPrepareStatement)
-	collectData(br, BenchmarkPrepareStatement)
+			br = testing.Benchmark(BenchmarkPrepareStatement)
+			collectData(br, BenchmarkPrepareStatement, suffix)
+		}
 
-	for i := 0; i < int(threadPtr); i++ {
-		go collectData(testing.Benchmark(BenchmarkProcessData), BenchmarkProcessData)
-	}
+		step := WorkloadStep{
+			Action:    operationPtr,
+			Flag:      flagPtr,
+			Table:     tablePtr,
+			Columns:   columnsPtr,
+			Condition: conditionPtr,
+			Random:    randomPtr == "true",
+			Count:     int(countPtr),
+			Params:    params,
+			Bulk:      bulkPtr,
+			Batch:     batchPtr,
+		}
+		step.validateBulk("--bulk")
+		pool := newWorkerPool(int(threadPtr), 1, dbPtr)
+		defer pool.close()
+		step.prepare(pool.workers[0].db)
+		pool.prepare([]WorkloadStep{step})
+
+		operation := strings.ToUpper(step.Action)
+		if step.Bulk != "" {
+			operation = fmt.Sprintf("%s %s x%d", operation, step.Bulk, step.Batch)
+		}
+		name := fmt.Sprintf("main.workerPool[%d] %s%s", len(pool.workers), operation, suffix)
+		var memBefore, memAfter runtime.MemStats
+		runtime.ReadMemStats(&memBefore)
+		sched := schedule{iterations: step.Count, duration: durationPtr, rate: ratePtr}
+		status := startStatusProbe(name, []target{{host: hostNamePtr, port: portPtr}})
+		reporter := startIntervalReporter(pool, intervalPtr, status)
+		elapsed := pool.run(func(w *worker) {
+			w.loop(sched, len(pool.workers), func(intended time.Time) {
+				w.execStepAt(0, &step, intended)
+			})
+		})
+		reporter.Stop()
+		status.finish()
+		runtime.ReadMemStats(&memAfter)
+
+		stats := pool.stats()
+		collectResult(name, elapsed, stats[0].iterations,
+			fmt.Sprintf("%d", memAfter.Mallocs-memBefore.Mallocs), fmt.Sprintf("%d", memAfter.TotalAlloc-memBefore.TotalAlloc), &stats[0])
+		if step.Bulk != "" {
+			collectBulk(name, &step, elapsed, &stats[0])
+		}
+		printThroughput(stats[0].iterations, elapsed, sched.rate)
+	})
 	printData()
 }
 
+// printThroughput prints the achieved throughput of a run, and the offered
+// load if the run was rate limited.
+func printThroughput(iterations int, elapsed time.Duration, rate float64) {
+	achieved := float64(iterations) / elapsed.Seconds()
+	if rate > 0 {
+		fmt.Println(fmt.Sprintf("Throughput: %.1f queries/sec achieved, %.1f queries/sec offered", achieved, rate))
+	} else {
+		fmt.Println(fmt.Sprintf("Throughput: %.1f queries/sec", achieved))
+	}
+}
+
 func writeToFile() *os.File {
 	filePtr, err := os.OpenFile(fmt.Sprintf("./results/%s.%s.%s", logPrefix, logType, strconv.FormatInt(time.Now().Unix(), 10)), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
 	if err != nil {
@@ -481,56 +432,140 @@ func writeToFile() *os.File {
 	return filePtr
 }
 
-func collectData(br testing.BenchmarkResult, funcPtr func(*testing.B)) {
+// collectData appends the result of a testing.Benchmark run, labeled with
+// the function name followed by suffix.
+func collectData(br testing.BenchmarkResult, funcPtr func(*testing.B), suffix string) {
 	if logType == "json" && logPrefix != "" {
 		benchmarkStr := fmt.Sprintf("%s,%s", br.T, br.N*int(countPtr))
 		benchmarkData = append(benchmarkData, string(benchmarkStr))
-		benchBuffer = append(benchBuffer, []string{fmt.Sprintf("%s", GetFunctionName(funcPtr)), fmt.Sprintf("%s", br.T), fmt.Sprintf("%d", br.N*int(countPtr)), fmt.Sprintf("%d", br.MemAllocs), fmt.Sprintf("%d", br.MemBytes)})
+		collectResult(GetFunctionName(funcPtr)+suffix, br.T, br.N*int(countPtr), fmt.Sprintf("%d", br.MemAllocs), fmt.Sprintf("%d", br.MemBytes), nil)
 	} else if logType == "csv" && logPrefix != "" {
 		benchmarkStr := fmt.Sprintf("%s,%s", br.T, br.N*int(countPtr))
 		benchmarkData = append(benchmarkData, string(benchmarkStr))
-		benchBuffer = append(benchBuffer, []string{fmt.Sprintf("%s", GetFunctionName(funcPtr)), fmt.Sprintf("%s", br.T), fmt.Sprintf("%d", br.N*int(countPtr)), fmt.Sprintf("%d", br.MemAllocs), fmt.Sprintf("%d", br.MemBytes)})
+		collectResult(GetFunctionName(funcPtr)+suffix, br.T, br.N*int(countPtr), fmt.Sprintf("%d", br.MemAllocs), fmt.Sprintf("%d", br.MemBytes), nil)
 	} else {
-		benchBuffer = append(benchBuffer, []string{fmt.Sprintf("%s", GetFunctionName(funcPtr)), fmt.Sprintf("%s", br.T), fmt.Sprintf("%d", br.N*int(countPtr)), fmt.Sprintf("%d", br.MemAllocs), fmt.Sprintf("%d", br.MemBytes)})
+		collectResult(GetFunctionName(funcPtr)+suffix, br.T, br.N*int(countPtr), fmt.Sprintf("%d", br.MemAllocs), fmt.Sprintf("%d", br.MemBytes), nil)
+	}
+}
+
+// collectResult appends a single row to the report.  stats may be nil for
+// results that were not measured per operation.
+func collectResult(name string, elapsed time.Duration, iterations int, memAllocs string, memBytes string, stats *stepStats) {
+	benchBuffer = append(benchBuffer, append([]string{name, fmt.Sprintf("%s", elapsed), fmt.Sprintf("%d", iterations), memAllocs, memBytes}, statsColumns(stats)...))
+	resultDoc.Results = append(resultDoc.Results, newResultRecord(name, elapsed, iterations, memAllocs, memBytes, stats))
+	collectErrors(name, stats)
+}
+
+// statsColumns formats step statistics for the statsHeader columns.
+func statsColumns(stats *stepStats) []string {
+	if stats == nil {
+		return append([]string{"-", "-", "-"}, latencyColumns(nil)...)
 	}
+	return append([]string{fmt.Sprintf("%d", stats.rows), fmt.Sprintf("%d", stats.bytes), fmt.Sprintf("%d", stats.errors)}, latencyColumns(stats.latency)...)
+}
+
+// latencyColumns formats a histogram for the latencyHeader columns.
+func latencyColumns(latency *histogram) []string {
+	if latency == nil || latency.count == 0 {
+		columns := make([]string, len(latencyHeader))
+		for i := range columns {
+			columns[i] = "-"
+		}
+		return columns
+	}
+	columns := []string{fmt.Sprintf("%s", latency.min), fmt.Sprintf("%s", latency.mean())}
+	for _, p := range latencyPercentiles {
+		columns = append(columns, fmt.Sprintf("%s", latency.percentile(p)))
+	}
+	return append(columns, fmt.Sprintf("%s", latency.max))
 }
 
 func printData() {
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Function", "Time Taken", "Iterations", "MemAllocs", "MemBytes"})
+	table.SetHeader(benchHeader)
 
 	for _, value := range benchBuffer {
 		table.Append(value)
 	}
 	table.Render()
+
+	for _, report := range reportTables() {
+		table = tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(report[0])
+		table.AppendBulk(report[1:])
+		table.Render()
+	}
+}
+
+// reportTables returns the tables printed after the benchmark results, if
+// they hold any rows.  The first row of each is its header.
+func reportTables() [][][]string {
+	var tables [][][]string
+	for _, table := range [][][]string{append([][]string{transactionHeader}, transactionBuffer...), append([][]string{errorHeader}, errorBuffer...), append([][]string{lagSummaryHeader}, lagSummaryBuffer...),
+		append([][]string{bulkHeader}, bulkBuffer...), append([][]string{ddlHeader}, ddlBuffer...), append([][]string{replayHeader}, replayBuffer...),
+		append([][]string{statusHeader}, statusBuffer...), append([][]string{variablesHeader}, variablesBuffer...)} {
+		if len(table) > 1 {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// extraTables returns the tables that are logged after the benchmark
+// results: the report tables and the interval reports, replication lag polls
+// and interval server status, which are only printed while running.
+func extraTables() [][][]string {
+	tables := reportTables()
+	if len(intervalBuffer) > 0 {
+		tables = append(tables, append([][]string{intervalHeader}, intervalBuffer...))
+	}
+	if len(lagBuffer) > 0 {
+		tables = append(tables, append([][]string{lagHeader}, lagBuffer...))
+	}
+	if len(statusIntervalBuffer) > 0 {
+		tables = append(tables, append([][]string{statusIntervalHeader}, statusIntervalBuffer...))
+	}
+	return tables
+}
+
+func csvHeader(header []string) []string {
+	var headerSlice []string
+	for _, value := range header {
+		headerSlice = append(headerSlice, strings.ToLower(strings.Replace(value, " ", "", -1)))
+	}
+	return headerSlice
 }
 
 func exportData() {
 	if logType == "json" {
 		filePtr := writeToFile()
-		var tempString [][]string
-		for _, value := range benchBuffer {
-			tempString = append(tempString, value)
-		}
-		jsonString, _ := json.MarshalIndent(tempString, "", "  ")
-		for _, value := range jsonString {
-			_, err := filePtr.WriteString(string(value))
-			if err != nil {
-				log.Error("[%s]: Couldn't write to the JSON output file", GetFunctionName(exportData))
-			}
+		if err := writeResults(filePtr); err != nil {
+			log.Error("[%s]: Couldn't write to the JSON output file", GetFunctionName(exportData))
 		}
 		defer filePtr.Close()
 	} else if logType == "csv" {
 		filePtr := writeToFile()
 		csvWriter := csv.NewWriter(filePtr)
-		headerSlice := []string{"function", "timetaken", "iterations", "memallocs", "membytes"}
-		_ = csvWriter.Write(headerSlice)
+		_ = csvWriter.Write(csvHeader(benchHeader))
 		for _, value := range benchBuffer {
 			err := csvWriter.Write(value)
 			if err != nil {
 				log.Error("[%s]: Cannot write to CSV file", GetFunctionName(exportData))
 			}
 		}
+		// Transaction, error, lag, status and interval reports follow as separate tables,
+		// each after an empty line.
+		for _, table := range extraTables() {
+			csvWriter.Flush()
+			filePtr.WriteString("\n")
+			_ = csvWriter.Write(csvHeader(table[0]))
+			for _, value := range table[1:] {
+				err := csvWriter.Write(value)
+				if err != nil {
+					log.Error("[%s]: Cannot write to CSV file", GetFunctionName(exportData))
+				}
+			}
+		}
 		csvWriter.Flush()
 		defer filePtr.Close()
 	} else {
@@ -538,14 +573,78 @@ func exportData() {
 	}
 }
 
+// run runs the workload, the vector, the replay, the connection benchmark or
+// the single benchmark selected by the options.
+func run(workload *Workload, vector *Vector) {
+	if workload != nil {
+		runWorkload(workload)
+	} else if vector != nil {
+		runVector(vector)
+	} else if replayFile != "" {
+		runReplay(loadReplay(replayFile))
+	} else if isConnect() {
+		runConnect()
+	} else {
+		runBenchmarks()
+	}
+}
+
 func main() {
 	flag.Parse()
+	args := flag.Args()
+	showConfigOnly, compare, phases := isConfigShow(args), isCompare(args), isPhases(args)
+	if showConfigOnly {
+		args = parseInterspersed(args[2:])
+	} else if compare {
+		args = parseInterspersed(args[1:])
+	} else if len(phases) > 0 {
+		args = parseInterspersed(args[len(phases):])
+	}
+	recordCommandLine()
 
-	setPtrs()
+	// The config files and the environment may name the workload or
+	// vector, whose own settings are merged in a second pass.
+	loadConfig(nil)
+	if compare {
+		os.Exit(runCompare(args))
+	}
+	var workload *Workload
+	var vector *Vector
+	if workloadFile != "" {
+		workload = loadWorkload(workloadFile)
+		layer := workload.configLayer()
+		loadConfig(&layer)
+	} else if testVectorConfig != "" {
+		vector = loadVector(testVectorConfig)
+		layer := vector.configLayer()
+		loadConfig(&layer)
+	}
+	useFirstTarget()
+	resolveCredentials()
 
-	validateInput()
+	if showConfigOnly {
+		showConfig()
+		return
+	}
 
-	runBenchmarks()
+	validateInput()
+	if problems := phaseProblems(phases, workload); len(problems) > 0 {
+		log.Error("[%s]: %s", GetFunctionName(main), strings.Join(problems, " "))
+	}
+	if len(phases) == 0 {
+		phases = []string{phaseRun}
+	}
+	for _, phase := range phases {
+		switch phase {
+		case phasePrepare:
+			prepareDataset(workload)
+		case phaseCleanup:
+			cleanupDataset(workload)
+		default:
+			run(workload, vector)
+		}
+	}
+	closeStatusConns()
 
 	if logPrefix != "" {
 		exportData()
@@ -8,6 +8,7 @@ import (
 
 func TestTravis(t *testing.T) {
 	configParse("openstack-generic-config.json")
+	resolveCredentials()
 	cases := []struct {
 		testTableInput     string
 		testHostNameInput  string
@@ -24,8 +25,8 @@ func TestTravis(t *testing.T) {
 	}
 	for _, c := range cases {
 		db := initializeDB(USER, PASSWORD, c.testHostNameInput, c.testPortInput, c.testDBInput)
-		returnedRows := prepareStatement(db, c.testOperationInput, c.testFlagInput, c.testRandomInput, c.testColumnsInput, c.testTableInput, c.testConditionInput)
-		returnedOutput := processData(returnedRows, c.testColumnsInput, c.testTableInput)
+		returnedRows, err := prepareStatement(db, c.testOperationInput, c.testFlagInput, c.testRandomInput, c.testColumnsInput, c.testTableInput, c.testConditionInput)
+		returnedOutput := err == nil && processData(&rowBuffer{}, returnedRows) == nil
 		if returnedOutput != c.outputWanted {
 			t.Errorf("prepareStatement(%q, %q, %q, %q, %q) returned %q, want %q", c.testDBInput, c.testColumnsInput, c.testOperationInput, c.testFlagInput, c.testRandomInput, c.testTableInput, c.testConditionInput, returnedOutput, c.outputWanted)
 		}
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opendns/lemming/lib/log"
+)
+
+// The query log formats --replay reads.
+const (
+	logFormatAuto    = "auto"
+	logFormatGeneral = "general"
+	logFormatSlow    = "slow"
+)
+
+var replayFile, replayFormatPtr string
+var replaySpeedPtr float64
+
+// replayBuffer compares the replayed latency of every query class with the
+// Query_time the slow log recorded for it.
+var replayBuffer [][]string
+var replayHeader = []string{"Query", "Count", "Original Mean", "Replay Mean", "Mean Change", "Original P99", "Replay P99", "P99 Change"}
+
+func init() {
+	flag.StringVar(&replayFile, "replay", "", "Replay the queries of a MySQL general or slow query log, every original session on a connection of its own.")
+	flag.StringVar(&replayFormatPtr, "replayformat", logFormatAuto, "The format of the --replay log: auto, general or slow. pt-query-digest --output slowlog writes the slow format.")
+	flag.Float64Var(&replaySpeedPtr, "replayspeed", 1, "Replay the --replay log at this multiple of its original pace: e.g. 2 for twice as fast. 0 replays as fast as possible.")
+}
+
+var (
+	// generalLogEntry matches the first line of a general log entry: its
+	// time, which older servers only log when it changes, thread id,
+	// command and argument.
+	generalLogEntry = regexp.MustCompile(`^(?:(\d{4}-\d{2}-\d{2}T\S+|\d{6}\s+\d{1,2}:\d{2}:\d{2})\s+|\s+)(\d+) (Connect|Query|Execute|Init DB|Quit|Prepare|Close stmt|Reset stmt|Reset Connection|Change user|Field List|Statistics|Ping|Processlist|Kill|Refresh|Debug|Set option|Fetch|Long Data|Binlog Dump|Binlog Dump GTID|Register Slave|Shutdown|Sleep|Daemon)\t?(.*)$`)
+	// logHeaderLine matches the lines the server writes to both logs when
+	// it starts.
+	logHeaderLine      = regexp.MustCompile(`^(?:\S.*, Version: .*started with:|Tcp port: \d+\s+Unix socket: .*|Time\s+Id\s+Command\s+Argument)\s*$`)
+	connectDB          = regexp.MustCompile(` on (\S*)`)
+	slowLogField       = regexp.MustCompile(`(\w+): +(\S*)`)
+	userHostID         = regexp.MustCompile(`Id:\s*(\d+)`)
+	setTimestamp       = regexp.MustCompile(`(?i)^SET timestamp=(\d+)(?:\.\d+)?;$`)
+	useStatementSyntax = regexp.MustCompile("(?i)^use\\s+`?([^`;\\s]+)`?\\s*;?$")
+)
+
+// logEvent is a statement of a query log.
+type logEvent struct {
+	// session numbers the original sessions, thread is the server's id of
+	// the session's thread.
+	session int
+	thread  int64
+	// at is when the statement ran, zero if the log does not say.
+	at    time.Time
+	db    string
+	query string
+	// queryTime is the Query_time of a slow log, timed is false for logs
+	// without one.
+	queryTime time.Duration
+	timed     bool
+	// class is the index of the statement's fingerprint, see newReplay.
+	class int
+}
+
+// sessionTracker numbers the sessions of a log and follows their current
+// database.  The server reuses the id of a thread that quit, so a thread id
+// seen after a quit starts a new session.
+type sessionTracker struct {
+	sessions map[int64]int
+	dbs      map[int64]string
+	next     int
+}
+
+func newSessionTracker() *sessionTracker {
+	return &sessionTracker{sessions: make(map[int64]int), dbs: make(map[int64]string)}
+}
+
+// event returns the event of a statement of a thread, or false for a
+// statement that only changes the thread's database.
+func (tracker *sessionTracker) event(thread int64, at time.Time, query string) (logEvent, bool) {
+	query = strings.TrimSuffix(strings.TrimSpace(query), ";")
+	if match := useStatementSyntax.FindStringSubmatch(query); match != nil {
+		tracker.dbs[thread] = match[1]
+		return logEvent{}, false
+	}
+	if query == "" {
+		return logEvent{}, false
+	}
+	session, ok := tracker.sessions[thread]
+	if !ok {
+		session = tracker.next
+		tracker.sessions[thread] = session
+		tracker.next++
+	}
+	return logEvent{session: session, thread: thread, at: at, db: tracker.dbs[thread], query: query}, true
+}
+
+func (tracker *sessionTracker) quit(thread int64) {
+	delete(tracker.sessions, thread)
+	delete(tracker.dbs, thread)
+}
+
+// parseLogTime parses the times of general and slow logs: RFC 3339 since
+// MySQL 5.7, YYMMDD H:MM:SS in the server's time zone before.
+func parseLogTime(value string) (time.Time, error) {
+	if strings.Contains(value, "T") {
+		return time.Parse(time.RFC3339Nano, value)
+	}
+	return time.ParseInLocation("060102 15:04:05", strings.Join(strings.Fields(value), " "), time.Local)
+}
+
+func newLogScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	// A statement can be as long as max_allowed_packet.
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+	return scanner
+}
+
+// parseGeneralLog returns the statements of a general query log.  Queries
+// and executed prepared statements are replayed, connects, database changes
+// and quits only start and end sessions.
+func parseGeneralLog(r io.Reader) ([]logEvent, error) {
+	scanner := newLogScanner(r)
+	tracker := newSessionTracker()
+	var events []logEvent
+	var at time.Time
+	// pending holds the statement continuation lines belong to.
+	var pending []string
+	var pendingThread int64
+	flush := func() {
+		if event, ok := tracker.event(pendingThread, at, strings.Join(pending, "\n")); ok {
+			events = append(events, event)
+		}
+		pending = nil
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		match := generalLogEntry.FindStringSubmatch(line)
+		if match == nil {
+			if logHeaderLine.MatchString(line) {
+				if pending != nil {
+					flush()
+				}
+			} else if pending != nil {
+				pending = append(pending, line)
+			}
+			continue
+		}
+		if pending != nil {
+			flush()
+		}
+
+		if match[1] != "" {
+			t, err := parseLogTime(match[1])
+			if err != nil {
+				return nil, err
+			}
+			at = t
+		}
+		thread, _ := strconv.ParseInt(match[2], 10, 64)
+		switch match[3] {
+		case "Connect":
+			tracker.quit(thread)
+			if db := connectDB.FindStringSubmatch(match[4]); db != nil && db[1] != "using" {
+				tracker.dbs[thread] = db[1]
+			}
+		case "Init DB":
+			tracker.dbs[thread] = strings.TrimSpace(match[4])
+		case "Quit":
+			tracker.quit(thread)
+		case "Query", "Execute":
+			pending, pendingThread = []string{match[4]}, thread
+		}
+	}
+	if pending != nil {
+		flush()
+	}
+	return events, scanner.Err()
+}
+
+// parseSlowLog returns the statements of a slow query log, including the
+// extended logs of Percona Server and MariaDB and pt-query-digest --output
+// slowlog.  Every entry is a block of "# " header lines followed by its
+// statement.
+func parseSlowLog(r io.Reader) ([]logEvent, error) {
+	scanner := newLogScanner(r)
+	tracker := newSessionTracker()
+	var events []logEvent
+	// The header of the current entry.  Servers before MySQL 5.7 only log
+	// the time when it changes, so it carries over to the next entry.
+	var at time.Time
+	var thread int64
+	var db string
+	var queryTime time.Duration
+	var timed, timeLogged bool
+	var statement []string
+	inStatement := false
+	flush := func() {
+		if db != "" {
+			tracker.dbs[thread] = db
+		}
+		if event, ok := tracker.event(thread, at, strings.Join(statement, "\n")); ok {
+			event.queryTime, event.timed = queryTime, timed
+			events = append(events, event)
+		}
+		thread, db, queryTime, timed, timeLogged, statement, inStatement = 0, "", 0, false, false, nil, false
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case logHeaderLine.MatchString(line):
+			continue
+		case strings.HasPrefix(line, "# administrator command: "):
+			// Commands other than queries, like Quit or Ping.
+			if strings.Contains(line, "Quit") {
+				tracker.quit(thread)
+			}
+			statement = nil
+			inStatement = true
+			continue
+		case !strings.HasPrefix(line, "# "):
+			if match := useStatementSyntax.FindStringSubmatch(strings.TrimSpace(line)); match != nil {
+				db = match[1]
+			} else if match := setTimestamp.FindStringSubmatch(strings.TrimSpace(line)); match != nil && !timeLogged {
+				seconds, _ := strconv.ParseInt(match[1], 10, 64)
+				at = time.Unix(seconds, 0)
+			} else if match == nil {
+				statement = append(statement, line)
+			}
+			inStatement = true
+			continue
+		}
+
+		if inStatement {
+			flush()
+		}
+		switch {
+		case strings.HasPrefix(line, "# Time: "):
+			t, err := parseLogTime(strings.TrimSpace(strings.TrimPrefix(line, "# Time: ")))
+			if err != nil {
+				return nil, err
+			}
+			at, timeLogged = t, true
+		case strings.HasPrefix(line, "# User@Host: "):
+			if match := userHostID.FindStringSubmatch(line); match != nil {
+				thread, _ = strconv.ParseInt(match[1], 10, 64)
+			}
+		default:
+			for _, field := range slowLogField.FindAllStringSubmatch(line, -1) {
+				switch field[1] {
+				case "Thread_id":
+					thread, _ = strconv.ParseInt(field[2], 10, 64)
+				case "Schema":
+					db = field[2]
+				case "Query_time":
+					seconds, err := strconv.ParseFloat(field[2], 64)
+					if err != nil {
+						return nil, fmt.Errorf("%s: %s", line, err.Error())
+					}
+					queryTime, timed = time.Duration(seconds*float64(time.Second)), true
+				}
+			}
+		}
+	}
+	if inStatement {
+		flush()
+	}
+	return events, scanner.Err()
+}
+
+// detectLogFormat tells general and slow logs apart by their first entries.
+func detectLogFormat(head string) string {
+	for _, line := range strings.Split(head, "\n") {
+		switch {
+		case strings.HasPrefix(line, "# Time: "), strings.HasPrefix(line, "# User@Host: "), strings.HasPrefix(line, "# Query_time: "):
+			return logFormatSlow
+		case generalLogEntry.MatchString(line):
+			return logFormatGeneral
+		}
+	}
+	return ""
+}
+
+// readQueryLog returns the statements of a query log of the given format.
+func readQueryLog(r io.Reader, format string) ([]logEvent, error) {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	if format == logFormatAuto {
+		head, _ := reader.Peek(64 * 1024)
+		if format = detectLogFormat(string(head)); format == "" {
+			return nil, fmt.Errorf("not a general or slow query log, please specify the --replayformat")
+		}
+	}
+	if format == logFormatGeneral {
+		return parseGeneralLog(reader)
+	}
+	return parseSlowLog(reader)
+}
+
+var (
+	fingerprintComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	fingerprintString  = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.|"")*"`)
+	fingerprintNumber  = regexp.MustCompile(`\b(?:0x[0-9a-fA-F]+|\d+(?:\.\d+)?(?:[eE][+-]?\d+)?)\b`)
+	fingerprintList    = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)(?:\s*,\s*\(\s*\?(?:\s*,\s*\?)*\s*\))*`)
+	fingerprintSpace   = regexp.MustCompile(`\s+`)
+)
+
+// fingerprint returns a query with its literals replaced by ?, so that the
+// executions of a statement with different values fall into one class.
+// Lists of values, like those of IN and multi-row INSERTs, become (?+).
+func fingerprint(query string) string {
+	query = fingerprintComment.ReplaceAllString(query, " ")
+	query = fingerprintString.ReplaceAllString(query, "?")
+	query = fingerprintNumber.ReplaceAllString(query, "?")
+	query = fingerprintList.ReplaceAllString(query, "(?+)")
+	query = fingerprintSpace.ReplaceAllString(query, " ")
+	return strings.ToLower(strings.TrimSpace(query))
+}
+
+// replay is a query log ready to be replayed.
+type replay struct {
+	sessions [][]logEvent
+	// classes holds a step for every fingerprint, which errors are
+	// reported for, fingerprints the fingerprints and original the
+	// Query_time of the statements of each.
+	classes      []WorkloadStep
+	fingerprints []string
+	original     []*histogram
+	// first is the time of the earliest statement, span how much time the
+	// log covers.
+	first   time.Time
+	span    time.Duration
+	queries int
+}
+
+func newReplay(events []logEvent) *replay {
+	r := &replay{queries: len(events)}
+	classes := make(map[string]int)
+	var last time.Time
+	for _, event := range events {
+		print := fingerprint(event.query)
+		class, ok := classes[print]
+		if !ok {
+			class = len(r.classes)
+			classes[print] = class
+			name := print
+			if len(name) > 60 {
+				name = name[:57] + "..."
+			}
+			r.classes = append(r.classes, WorkloadStep{Name: name, Query: print, returnsRows: returnsRows(print)})
+			r.fingerprints = append(r.fingerprints, print)
+			r.original = append(r.original, newHistogram())
+		}
+		event.class = class
+		if event.timed {
+			r.original[class].record(event.queryTime)
+		}
+		for event.session >= len(r.sessions) {
+			r.sessions = append(r.sessions, nil)
+		}
+		r.sessions[event.session] = append(r.sessions[event.session], event)
+
+		if event.at.IsZero() {
+			continue
+		}
+		if r.first.IsZero() || event.at.Before(r.first) {
+			r.first = event.at
+		}
+		if event.at.After(last) {
+			last = event.at
+		}
+	}
+	r.span = last.Sub(r.first)
+	return r
+}
+
+// loadReplay reads the --replay log.
+func loadReplay(name string) *replay {
+	file, err := os.Open(name)
+	if err != nil {
+		log.Error(fmt.Sprintf("Replay File IO Error: %s\n", err.Error()))
+	}
+	defer file.Close()
+
+	events, err := readQueryLog(file, replayFormatPtr)
+	if err != nil {
+		log.Error("[%s]: %s: %s", GetFunctionName(loadReplay), name, err.Error())
+	}
+	if len(events) == 0 {
+		log.Error("[%s]: %s: no queries found", GetFunctionName(loadReplay), name)
+	}
+	return newReplay(events)
+}
+
+// validateReplay returns the problems of the --replay options.
+func validateReplay() []string {
+	var problems []string
+	switch replayFormatPtr {
+	case logFormatAuto, logFormatGeneral, logFormatSlow:
+	default:
+		problems = append(problems, fmt.Sprintf("Please specify --replayformat=%s, %s or %s.", logFormatAuto, logFormatGeneral, logFormatSlow))
+	}
+	if replaySpeedPtr < 0 {
+		problems = append(problems, "Please specify a positive --replayspeed.")
+	}
+	if replayFile != "" && (workloadFile != "" || testVectorConfig != "") {
+		problems = append(problems, "Please specify only one of --workload, --vector and --replay.")
+	}
+	return problems
+}
+
+// intended returns when an event is due: at its offset into the log,
+// divided by speed, after start.  All events are due at start when the log
+// has no times or speed is 0.
+func (r *replay) intended(event *logEvent, start time.Time, speed float64) time.Time {
+	if speed <= 0 || event.at.IsZero() || r.first.IsZero() {
+		return start
+	}
+	return start.Add(time.Duration(float64(event.at.Sub(r.first)) / speed))
+}
+
+// replaySession replays the statements of a session on a connection of its
+// own, recording them with recorder.  It returns how far the session fell
+// behind the pace of the log.
+func (r *replay) replaySession(session int, recorder *worker, start time.Time) time.Duration {
+	w := newWorker(session, nil, 0)
+	// The logged statements carry their values, there is nothing to
+	// prepare.
+	w.prepared = false
+	var current string
+	var behind time.Duration
+	defer func() {
+		if w.db != nil {
+			w.db.Close()
+		}
+	}()
+
+	for i := range r.sessions[session] {
+		event := &r.sessions[session][i]
+		wait := r.intended(event, start, replaySpeedPtr).Sub(time.Now())
+		if wait > 0 {
+			time.Sleep(wait)
+		} else if -wait > behind {
+			behind = -wait
+		}
+		if w.db == nil {
+			w.db = initializeDB(USER, PASSWORD, hostNamePtr, portPtr, dbPtr)
+			w.db.SetMaxOpenConns(1)
+			w.db.SetMaxIdleConns(1)
+			current = dbPtr
+		}
+		if event.db != "" && event.db != current {
+			if _, err := w.db.Exec("USE `" + strings.Replace(event.db, "`", "``", -1) + "`"); err != nil {
+				log.Warning(fmt.Sprintf("[%s]: thread %d: %s", GetFunctionName((*replay).replaySession), event.thread, err.Error()))
+			} else {
+				current = event.db
+			}
+		}
+		recorder.record(event.class, w.replayStatement(&r.classes[event.class], event))
+	}
+	return behind
+}
+
+// replayStatement runs a logged statement, retrying it like a workload step
+// of its class.  Its latency is measured from when it was sent, like the
+// Query_time of the log.
+func (w *worker) replayStatement(class *WorkloadStep, event *logEvent) sample {
+	step := WorkloadStep{query: event.query, returnsRows: class.returnsRows}
+	start := time.Now()
+	var failures [errorClasses]int
+	var result sample
+	attempt := 0
+	for ; ; attempt++ {
+		var err error
+		result, err = w.exec(nil, event.class, &step)
+		if err == nil {
+			break
+		}
+		failures[classifyError(err)]++
+		if !w.retryError(class, err, attempt) {
+			result.failed = true
+			break
+		}
+	}
+	result.failures, result.retries = failures, attempt
+	result.busy = time.Since(start)
+	result.latency = result.busy
+	return result
+}
+
+// compareLatency returns the replay report row of a query class.
+func compareLatency(name string, original *histogram, replayed *histogram) []string {
+	row := []string{name, fmt.Sprintf("%d", replayed.count), "-", "-", "-", "-", "-", "-"}
+	if original.count > 0 {
+		row[2], row[5] = fmt.Sprintf("%s", original.mean()), fmt.Sprintf("%s", original.percentile(99))
+	}
+	if replayed.count > 0 {
+		row[3], row[6] = fmt.Sprintf("%s", replayed.mean()), fmt.Sprintf("%s", replayed.percentile(99))
+	}
+	if change, ok := percentChange(float64(original.mean()), float64(replayed.mean())); ok && original.count > 0 && replayed.count > 0 {
+		row[4] = fmt.Sprintf("%+.1f%%", change)
+	}
+	if change, ok := percentChange(float64(original.percentile(99)), float64(replayed.percentile(99))); ok && original.count > 0 && replayed.count > 0 {
+		row[7] = fmt.Sprintf("%+.1f%%", change)
+	}
+	return row
+}
+
+func runReplay(r *replay) {
+	fmt.Println(fmt.Sprintf("Replaying %d queries of %d sessions from %s, please wait...", r.queries, len(r.sessions), replayFile))
+
+	if logPrefix == "" {
+		log.Warning(fmt.Sprintf("[%s]: No --logprefix defined, log file will NOT be created", GetFunctionName(exportData)))
+	}
+
+	// Sessions open their connection when their first statement is due.
+	// A single worker records the statements of all of them, with a slot
+	// per query class.
+	recorder := newWorker(0, nil, len(r.classes))
+	pool := &workerPool{workers: []*worker{recorder}}
+	behind := make([]time.Duration, len(r.sessions))
+	order := make([]int, len(r.sessions))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		var base time.Time
+		return r.intended(&r.sessions[order[i]][0], base, replaySpeedPtr).Before(r.intended(&r.sessions[order[j]][0], base, replaySpeedPtr))
+	})
+
+	var memBefore, memAfter runtime.MemStats
+	runtime.ReadMemStats(&memBefore)
+	status := startStatusProbe("replay "+filepath.Base(replayFile), []target{{host: hostNamePtr, port: portPtr}})
+	reporter := startIntervalReporter(pool, intervalPtr, status)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, session := range order {
+		if wait := r.intended(&r.sessions[session][0], start, replaySpeedPtr).Sub(time.Now()); wait > 0 {
+			time.Sleep(wait)
+		}
+		wg.Add(1)
+		go func(session int) {
+			defer wg.Done()
+			behind[session] = r.replaySession(session, recorder, start)
+		}(session)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	reporter.Stop()
+	status.finish()
+	runtime.ReadMemStats(&memAfter)
+
+	stats := pool.stats()
+	totalStats := newStepStats(1)[0]
+	original := newHistogram()
+	for i := range r.classes {
+		collectResult(r.classes[i].label(i), elapsed, stats[i].iterations, "-", "-", &stats[i])
+		totalStats.add(&stats[i])
+		original.merge(r.original[i])
+		replayBuffer = append(replayBuffer, compareLatency(r.classes[i].label(i), r.original[i], stats[i].latency))
+		resultDoc.Replay = append(resultDoc.Replay, replayRecord{Query: r.fingerprints[i], Count: stats[i].iterations,
+			OriginalLatencyMs: newLatencyRecord(r.original[i]), LatencyMs: newLatencyRecord(stats[i].latency)})
+	}
+	name := "replay " + filepath.Base(replayFile)
+	collectResult(name, elapsed, totalStats.iterations,
+		fmt.Sprintf("%d", memAfter.Mallocs-memBefore.Mallocs), fmt.Sprintf("%d", memAfter.TotalAlloc-memBefore.TotalAlloc), &totalStats)
+	replayBuffer = append(replayBuffer, compareLatency(name, original, totalStats.latency))
+	printData()
+
+	var maxBehind time.Duration
+	for _, d := range behind {
+		if d > maxBehind {
+			maxBehind = d
+		}
+	}
+	pace := fmt.Sprintf("%gx its original pace", replaySpeedPtr)
+	if replaySpeedPtr == 0 {
+		pace = "as fast as possible"
+	}
+	fmt.Println(fmt.Sprintf("Replayed %s of log in %s at %s, sessions fell up to %s behind", r.span, elapsed, pace, maxBehind.Truncate(time.Millisecond)))
+	printThroughput(totalStats.iterations, elapsed, 0)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const generalLog = `/usr/sbin/mysqld, Version: 8.0.36 (MySQL Community Server - GPL). started with:
+Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock
+Time                 Id Command    Argument
+2024-01-15T10:00:00.000000Z	   12 Connect	app@10.0.0.1 on employees using TCP/IP
+2024-01-15T10:00:00.100000Z	   12 Query	SELECT * FROM employees WHERE emp_no = 10001
+2024-01-15T10:00:00.200000Z	   13 Connect	app@10.0.0.2 on  using TCP/IP
+2024-01-15T10:00:00.300000Z	   13 Init DB	employees
+2024-01-15T10:00:00.400000Z	   13 Query	UPDATE salaries
+SET salary = 60000
+WHERE emp_no = 10002
+2024-01-15T10:00:00.500000Z	   12 Quit
+2024-01-15T10:00:01.000000Z	   12 Connect	app@10.0.0.1 on employees using TCP/IP
+2024-01-15T10:00:01.500000Z	   12 Query	SELECT * FROM employees WHERE emp_no = 10003
+`
+
+const slowLog = `# Time: 2024-01-15T10:00:00.250000Z
+# User@Host: app[app] @  [10.0.0.1]  Id:    12
+# Query_time: 0.002500  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 1
+use employees;
+SET timestamp=1705312800;
+SELECT * FROM employees WHERE emp_no = 10001;
+# Time: 2024-01-15T10:00:02.000000Z
+# User@Host: app[app] @  [10.0.0.2]
+# Thread_id: 13  Schema: payroll  QC_hit: No
+# Query_time: 1.250000  Lock_time: 0.000000 Rows_sent: 0  Rows_examined: 300024
+SET timestamp=1705312802;
+SELECT emp_no, SUM(salary)
+FROM salaries GROUP BY emp_no;
+# User@Host: app[app] @  [10.0.0.2]  Id:    13
+# Query_time: 0.000100  Lock_time: 0.000000 Rows_sent: 0  Rows_examined: 0
+SET timestamp=1705312803;
+# administrator command: Quit;
+`
+
+func TestParseGeneralLog(t *testing.T) {
+	events, err := parseGeneralLog(strings.NewReader(generalLog))
+	if err != nil {
+		t.Fatalf("parseGeneralLog() error = %s", err.Error())
+	}
+	if len(events) != 3 {
+		t.Fatalf("parseGeneralLog() = %+v, want 3 statements", events)
+	}
+	if e := events[0]; e.session != 0 || e.thread != 12 || e.db != "employees" || e.at.Nanosecond() != 100000000 || e.timed {
+		t.Errorf("first statement = %+v", e)
+	}
+	if e := events[1]; e.session != 1 || e.db != "employees" || e.query != "UPDATE salaries\nSET salary = 60000\nWHERE emp_no = 10002" {
+		t.Errorf("multi-line statement = %+v", e)
+	}
+	// Thread 12 quit, so its id starts a new session.
+	if e := events[2]; e.session != 2 || e.thread != 12 {
+		t.Errorf("statement after quit = %+v, want session 2 of thread 12", e)
+	}
+}
+
+func TestParseSlowLog(t *testing.T) {
+	events, err := parseSlowLog(strings.NewReader(slowLog))
+	if err != nil {
+		t.Fatalf("parseSlowLog() error = %s", err.Error())
+	}
+	if len(events) != 2 {
+		t.Fatalf("parseSlowLog() = %+v, want 2 statements", events)
+	}
+	if e := events[0]; e.thread != 12 || e.db != "employees" || e.query != "SELECT * FROM employees WHERE emp_no = 10001" || !e.timed || e.queryTime != 2500*time.Microsecond {
+		t.Errorf("first statement = %+v", e)
+	}
+	if e := events[1]; e.thread != 13 || e.session != 1 || e.db != "payroll" || e.queryTime != 1250*time.Millisecond || !e.at.Equal(time.Date(2024, 1, 15, 10, 0, 2, 0, time.UTC)) {
+		t.Errorf("Percona statement = %+v", e)
+	}
+}
+
+func TestDetectLogFormat(t *testing.T) {
+	if format := detectLogFormat(generalLog); format != logFormatGeneral {
+		t.Errorf("detectLogFormat(general log) = %q", format)
+	}
+	if format := detectLogFormat(slowLog); format != logFormatSlow {
+		t.Errorf("detectLogFormat(slow log) = %q", format)
+	}
+	if format := detectLogFormat("emp_no,salary\n10001,60000\n"); format != "" {
+		t.Errorf("detectLogFormat(CSV) = %q, want none", format)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	tests := map[string]string{
+		"SELECT * FROM employees WHERE emp_no = 10001":                   "select * from employees where emp_no = ?",
+		"select *  from employees\nwhere last_name = 'O''Brien' /* x */": "select * from employees where last_name = ?",
+		"SELECT * FROM t1 WHERE id IN (1, 2, 3)":                         "select * from t1 where id in (?+)",
+		"INSERT INTO salaries VALUES (1, 2.5, \"a\"), (2, 3e2, 'b')":     "insert into salaries values (?+)",
+	}
+	for query, want := range tests {
+		if got := fingerprint(query); got != want {
+			t.Errorf("fingerprint(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestNewReplay(t *testing.T) {
+	events, _ := parseGeneralLog(strings.NewReader(generalLog))
+	r := newReplay(events)
+	if len(r.sessions) != 3 || len(r.classes) != 2 || r.queries != 3 {
+		t.Fatalf("newReplay() = %d sessions, %d classes, %d queries, want 3, 2 and 3", len(r.sessions), len(r.classes), r.queries)
+	}
+	if r.sessions[2][0].class != 0 || !r.classes[0].returnsRows || r.classes[1].returnsRows {
+		t.Errorf("newReplay() classes = %+v", r.classes)
+	}
+	if r.span != 1400*time.Millisecond {
+		t.Errorf("newReplay() span = %s, want 1.4s", r.span)
+	}
+
+	start := time.Now()
+	if got := r.intended(&r.sessions[2][0], start, 2); got != start.Add(700*time.Millisecond) {
+		t.Errorf("intended() at 2x = %s after start, want 700ms", got.Sub(start))
+	}
+	if got := r.intended(&r.sessions[2][0], start, 0); got != start {
+		t.Errorf("intended() at speed 0 = %s after start, want 0", got.Sub(start))
+	}
+}
+
+func TestCompareLatency(t *testing.T) {
+	original, replayed := newHistogram(), newHistogram()
+	original.record(10 * time.Millisecond)
+	replayed.record(12 * time.Millisecond)
+	if row := compareLatency("q", original, replayed); row[1] != "1" || row[4] != "+20.0%" {
+		t.Errorf("compareLatency() = %q, want 1 query 20%% slower", row)
+	}
+	if row := compareLatency("q", newHistogram(), replayed); row[2] != "-" || row[4] != "-" {
+		t.Errorf("compareLatency() without Query_time = %q", row)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opendns/lemming/lib/log"
+)
+
+// The target roles --split routes steps to.
+const (
+	rolePrimary = "primary"
+	roleReplica = "replica"
+)
+
+var splitPtr bool
+var lagPollPtr time.Duration
+
+// lagBuffer holds the replication lag time series, one row per replica and
+// poll.  lagSummaryBuffer holds one row per replica.
+var lagBuffer [][]string
+var lagHeader = []string{"Elapsed", "Replica", "Seconds Behind Master", "Writes/sec", "Reads/sec"}
+var lagSummaryBuffer [][]string
+var lagSummaryHeader = []string{"Replica", "Polls", "Min Lag", "Mean Lag", "Max Lag", "Not Replicating"}
+
+func init() {
+	flag.BoolVar(&splitPtr, "split", false, "Send the workload steps without a role that write to the primary=... and the ones that read to the replica=... --targets.")
+	flag.DurationVar(&lagPollPtr, "lagpoll", 0, "Poll the replication lag of the replica=... --targets every interval during a workload: e.g. 1s. 0 disables polling.")
+}
+
+// isRead reports whether a step only reads, so that it can run on a replica.
+// Locking reads and transactions run on the primary.
+func (step *WorkloadStep) isRead() bool {
+	if step.Transaction != nil {
+		return false
+	}
+	switch strings.ToUpper(step.Action) {
+	case "SELECT", "SHOW", "DESCRIBE", "EXPLAIN":
+	default:
+		return false
+	}
+	statement := strings.ToUpper(step.Query + " " + step.Condition)
+	return !strings.Contains(statement, "FOR UPDATE") && !strings.Contains(statement, "LOCK IN SHARE MODE") && !strings.Contains(statement, "FOR SHARE")
+}
+
+// splitRoles routes the steps without a role: reads to the replicas, writes
+// to the primary.
+func splitRoles(steps []WorkloadStep) {
+	for i := range steps {
+		step := &steps[i]
+		if step.Role != "" {
+			continue
+		}
+		step.Role = rolePrimary
+		if step.isRead() {
+			step.Role = roleReplica
+		}
+		if step.Transaction != nil {
+			for j := range step.Transaction.Statements {
+				step.Transaction.Statements[j].Role = step.Role
+			}
+		}
+	}
+}
+
+// validateSplit returns the problems of the --split and --lagpoll options.
+func validateSplit() []string {
+	var problems []string
+	if !splitPtr && lagPollPtr == 0 {
+		return nil
+	}
+	if workloadFile == "" {
+		problems = append(problems, "Please specify a --workload to --split or --lagpoll.")
+	}
+	roles := make(map[string]bool)
+	if targets, err := parseTargets(targetsPtr); err == nil {
+		for _, t := range targets {
+			roles[t.role] = true
+		}
+	}
+	if splitPtr {
+		if !roles[rolePrimary] || !roles[roleReplica] {
+			problems = append(problems, "Please specify primary=... and replica=... --targets to --split reads and writes.")
+		}
+		if fanoutPtr {
+			problems = append(problems, "Please specify either --split or --fanout.")
+		}
+	}
+	if lagPollPtr < 0 {
+		problems = append(problems, "Please specify a positive --lagpoll.")
+	} else if lagPollPtr > 0 && !roles[roleReplica] {
+		problems = append(problems, "Please specify replica=... --targets to --lagpoll.")
+	}
+	return problems
+}
+
+// replicaLag returns the Seconds_Behind_Master of a replica.  known is false
+// if replication is not running, which the server reports as NULL.
+func replicaLag(db *sql.DB) (seconds int64, known bool, err error) {
+	rows, err := db.Query("SHOW SLAVE STATUS")
+	if mysqlErrorNumber(err) == errParseError {
+		// MySQL 8.4 only knows the new name.
+		rows, err = db.Query("SHOW REPLICA STATUS")
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, false, err
+	}
+	if !rows.Next() {
+		return 0, false, fmt.Errorf("not a replica")
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return 0, false, err
+	}
+	for i, column := range columns {
+		if column != "Seconds_Behind_Master" && column != "Seconds_Behind_Source" {
+			continue
+		}
+		if values[i] == nil {
+			return 0, false, nil
+		}
+		seconds, err := strconv.ParseInt(string(values[i]), 10, 64)
+		return seconds, err == nil, err
+	}
+	return 0, false, fmt.Errorf("no Seconds_Behind_Master column")
+}
+
+// replica is a replica the lag monitor polls.
+type replica struct {
+	name string
+	db   *sql.DB
+	// The lag samples of the run, in seconds.
+	polls, unknown int
+	min, max, sum  int64
+}
+
+// lagMonitor polls the replication lag of the replicas while a workload runs
+// and records it next to the throughput of the workload's writes and reads.
+type lagMonitor struct {
+	pool     *workerPool
+	replicas []*replica
+	// writes flags the slots of the statements that write.  Transaction
+	// steps have no entry, their statements are counted instead.
+	writes map[int]bool
+	every  time.Duration
+	start  time.Time
+	stop   chan struct{}
+	done   sync.WaitGroup
+}
+
+// startLagMonitor starts polling the replica targets every interval.  It
+// returns nil, and polls nothing, if interval is not positive.
+func startLagMonitor(pool *workerPool, workload *Workload, targets []target, interval time.Duration) *lagMonitor {
+	if interval <= 0 {
+		return nil
+	}
+
+	monitor := &lagMonitor{pool: pool, writes: make(map[int]bool), every: interval, start: time.Now(), stop: make(chan struct{})}
+	for i := range workload.Steps {
+		step := &workload.Steps[i]
+		if step.Transaction == nil {
+			monitor.writes[i] = !step.isRead()
+			continue
+		}
+		for j := range step.Transaction.Statements {
+			statement := &step.Transaction.Statements[j]
+			monitor.writes[statement.slot] = !statement.isRead()
+		}
+	}
+	for _, t := range roleTargets(targets)[roleReplica] {
+		db := initializeDB(USER, PASSWORD, t.host, t.port, "")
+		db.SetMaxOpenConns(1)
+		if _, _, err := replicaLag(db); err != nil {
+			log.Warning(fmt.Sprintf("[%s]: %s: %s, not polling its lag", GetFunctionName(startLagMonitor), t.name(), err.Error()))
+			db.Close()
+			continue
+		}
+		monitor.replicas = append(monitor.replicas, &replica{name: t.name(), db: db})
+	}
+
+	monitor.done.Add(1)
+	go monitor.loop()
+	return monitor
+}
+
+func (monitor *lagMonitor) loop() {
+	defer monitor.done.Done()
+	ticker := time.NewTicker(monitor.every)
+	defer ticker.Stop()
+	last, writes, reads := monitor.start, 0, 0
+	for {
+		select {
+		case <-ticker.C:
+		case <-monitor.stop:
+			return
+		}
+
+		now := time.Now()
+		totalWrites, totalReads := monitor.queries()
+		length := now.Sub(last).Seconds()
+		writeRate, readRate := float64(totalWrites-writes)/length, float64(totalReads-reads)/length
+		last, writes, reads = now, totalWrites, totalReads
+
+		elapsed := now.Sub(monitor.start).Truncate(time.Millisecond)
+		for _, r := range monitor.replicas {
+			lag := "NULL"
+			record := lagRecord{ElapsedSeconds: elapsed.Seconds(), Replica: r.name, WritesPerSecond: writeRate, ReadsPerSecond: readRate}
+			seconds, known, err := replicaLag(r.db)
+			switch {
+			case err != nil:
+				lag = "-"
+			case !known:
+				r.polls++
+				r.unknown++
+			default:
+				r.record(seconds)
+				lag = fmt.Sprintf("%d", seconds)
+				record.SecondsBehindMaster = &seconds
+			}
+			if err == nil {
+				resultDoc.ReplicationLag = append(resultDoc.ReplicationLag, record)
+			}
+			fmt.Println(fmt.Sprintf("[%8s] %s: %s seconds behind master, %.1f writes/sec, %.1f reads/sec", elapsed, r.name, lag, writeRate, readRate))
+			lagBuffer = append(lagBuffer, []string{fmt.Sprintf("%s", elapsed), r.name, lag, fmt.Sprintf("%.1f", writeRate), fmt.Sprintf("%.1f", readRate)})
+		}
+	}
+}
+
+// queries returns the number of write and read queries the workload ran so
+// far.
+func (monitor *lagMonitor) queries() (writes int, reads int) {
+	for slot, stats := range monitor.pool.stats() {
+		write, ok := monitor.writes[slot]
+		switch {
+		case !ok:
+		case write:
+			writes += stats.iterations
+		default:
+			reads += stats.iterations
+		}
+	}
+	return writes, reads
+}
+
+func (r *replica) record(seconds int64) {
+	if r.polls == r.unknown || seconds < r.min {
+		r.min = seconds
+	}
+	if seconds > r.max {
+		r.max = seconds
+	}
+	r.sum += seconds
+	r.polls++
+}
+
+// Stop stops polling and adds the lag summary of every replica to the
+// report.  It is safe to call on a nil monitor.
+func (monitor *lagMonitor) Stop() {
+	if monitor == nil {
+		return
+	}
+	close(monitor.stop)
+	monitor.done.Wait()
+	for _, r := range monitor.replicas {
+		r.db.Close()
+		lagSummaryBuffer = append(lagSummaryBuffer, r.summary())
+	}
+}
+
+// summary returns the report row of the replica.
+func (r *replica) summary() []string {
+	row := []string{r.name, fmt.Sprintf("%d", r.polls), "-", "-", "-", fmt.Sprintf("%d", r.unknown)}
+	if known := r.polls - r.unknown; known > 0 {
+		row[2], row[3], row[4] = fmt.Sprintf("%ds", r.min), fmt.Sprintf("%.1fs", float64(r.sum)/float64(known)), fmt.Sprintf("%ds", r.max)
+	}
+	return row
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitRoles(t *testing.T) {
+	steps := []WorkloadStep{
+		{Action: "SELECT", Table: "employees"},
+		{Action: "SELECT", Table: "employees", Condition: "WHERE emp_no = 10001 FOR UPDATE"},
+		{Action: "INSERT", Table: "employees"},
+		{Action: "SHOW", Query: "SHOW TABLES"},
+		{Action: "SELECT", Table: "salaries", Role: "analytics"},
+		{Transaction: &Transaction{Statements: []WorkloadStep{{Action: "SELECT", Table: "salaries"}}}},
+	}
+	splitRoles(steps)
+
+	want := []string{roleReplica, rolePrimary, rolePrimary, roleReplica, "analytics", rolePrimary}
+	var got []string
+	for _, step := range steps {
+		got = append(got, step.Role)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitRoles() roles = %q, want %q", got, want)
+	}
+	if role := steps[5].Transaction.Statements[0].Role; role != rolePrimary {
+		t.Errorf("transaction statement role = %q, want %q", role, rolePrimary)
+	}
+}
+
+func TestValidateSplit(t *testing.T) {
+	targets, workload, split, lagPoll, fanout := targetsPtr, workloadFile, splitPtr, lagPollPtr, fanoutPtr
+	defer func() {
+		targetsPtr, workloadFile, splitPtr, lagPollPtr, fanoutPtr = targets, workload, split, lagPoll, fanout
+	}()
+	workloadFile, splitPtr, lagPollPtr, fanoutPtr = "new-hires.json", true, 1, false
+
+	targetsPtr = "primary=db1,replica=db2"
+	if problems := validateSplit(); len(problems) != 0 {
+		t.Errorf("validateSplit() = %q, want no problems", problems)
+	}
+	targetsPtr = "db1,db2"
+	if problems := validateSplit(); len(problems) != 2 {
+		t.Errorf("validateSplit() without roles = %q, want 2 problems", problems)
+	}
+}
+
+func TestLagMonitorQueries(t *testing.T) {
+	w := newWorker(0, nil, 3)
+	w.steps[0].iterations, w.steps[1].iterations, w.steps[2].iterations = 10, 4, 7
+	// Slot 1 is a transaction step, whose statements are counted instead.
+	monitor := &lagMonitor{pool: &workerPool{workers: []*worker{w}}, writes: map[int]bool{0: false, 2: true}}
+
+	writes, reads := monitor.queries()
+	if writes != 7 || reads != 10 {
+		t.Errorf("queries() = %d writes, %d reads, want 7 and 10", writes, reads)
+	}
+}
+
+func TestReplicaSummary(t *testing.T) {
+	r := &replica{name: "db2:3306"}
+	r.record(3)
+	r.record(1)
+	r.polls++
+	r.unknown++
+	r.record(8)
+
+	want := []string{"db2:3306", "4", "1s", "4.0s", "8s", "1"}
+	if got := r.summary(); !reflect.DeepEqual(got, want) {
+		t.Errorf("summary() = %q, want %q", got, want)
+	}
+	if got := (&replica{name: "db3:3306"}).summary(); got[2] != "-" {
+		t.Errorf("summary() without polls = %q", got)
+	}
+}
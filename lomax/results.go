@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/opendns/lemming/lib/log"
+)
+
+// version is the lomax version recorded in JSON results.  Release builds set
+// it with -ldflags "-X main.version=1.2.3".
+var version = "dev"
+
+// The JSON result document.  Fields are only ever added within a schema
+// version; renaming or removing one, or changing its unit, needs a new
+// version.  Durations are float64 in the unit their name ends in.
+const (
+	resultSchema        = "lomax-result"
+	resultSchemaVersion = 1
+)
+
+// resultDoc collects the results of the run for the JSON export, next to the
+// table rows that are printed.
+var resultDoc = resultDocument{Schema: resultSchema, Version: resultSchemaVersion}
+
+// runStart is when the run started.
+var runStart = time.Now()
+
+type resultDocument struct {
+	Schema         string              `json:"schema"`
+	Version        int                 `json:"version"`
+	Run            runMetadata         `json:"run"`
+	Results        []resultRecord      `json:"results"`
+	Transactions   []transactionRecord `json:"transactions,omitempty"`
+	Errors         []errorRecord       `json:"errors,omitempty"`
+	Intervals      []intervalRecord    `json:"intervals,omitempty"`
+	ReplicationLag []lagRecord         `json:"replication_lag,omitempty"`
+	// The server status around every test and at every interval, see
+	// --serverstatus.
+	ServerStatus    []statusRecord         `json:"server_status,omitempty"`
+	StatusIntervals []statusIntervalRecord `json:"server_status_intervals,omitempty"`
+	ServerVariables []variableRecord       `json:"server_variables,omitempty"`
+	Replay          []replayRecord         `json:"replay,omitempty"`
+	Bulk            []bulkRecord           `json:"bulk,omitempty"`
+	DDL             []ddlRecord            `json:"ddl,omitempty"`
+}
+
+// runMetadata describes how and against what the results were measured.
+type runMetadata struct {
+	LomaxVersion string         `json:"lomax_version"`
+	Client       string         `json:"client_host"`
+	Mode         string         `json:"mode"`
+	Workload     string         `json:"workload,omitempty"`
+	Vector       string         `json:"vector,omitempty"`
+	Replay       string         `json:"replay,omitempty"`
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	Servers      []serverRecord `json:"servers"`
+	// Config holds the effective value of every option, see lomax config
+	// show.
+	Config map[string]string `json:"config"`
+}
+
+type serverRecord struct {
+	Address      string `json:"address"`
+	Role         string `json:"role,omitempty"`
+	MySQLVersion string `json:"mysql_version,omitempty"`
+}
+
+// latencyRecord holds latencies in milliseconds.
+type latencyRecord struct {
+	Count uint64  `json:"count"`
+	Min   float64 `json:"min"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	P999  float64 `json:"p99_9"`
+	Max   float64 `json:"max"`
+}
+
+type resultRecord struct {
+	Name string `json:"name"`
+	// Result is PASS or FAIL for the test cases of a vector, Error why
+	// a test case failed.
+	Result           string         `json:"result,omitempty"`
+	Error            string         `json:"error,omitempty"`
+	ElapsedSeconds   float64        `json:"elapsed_seconds"`
+	Iterations       int            `json:"iterations"`
+	QueriesPerSecond float64        `json:"queries_per_second"`
+	Rows             int64          `json:"rows"`
+	Bytes            int64          `json:"bytes"`
+	Errors           int            `json:"errors"`
+	Retries          int            `json:"retries"`
+	MemAllocs        *uint64        `json:"mem_allocs,omitempty"`
+	MemBytes         *uint64        `json:"mem_bytes,omitempty"`
+	LatencyMs        *latencyRecord `json:"latency_ms,omitempty"`
+}
+
+type transactionRecord struct {
+	Name         string         `json:"name"`
+	Transactions int            `json:"transactions"`
+	Commits      int            `json:"commits"`
+	Rollbacks    int            `json:"rollbacks"`
+	Failed       int            `json:"failed"`
+	Deadlocks    int            `json:"deadlocks"`
+	LockWaits    int            `json:"lock_wait_timeouts"`
+	Retries      int            `json:"retries"`
+	LatencyMs    *latencyRecord `json:"latency_ms,omitempty"`
+}
+
+type errorRecord struct {
+	Name    string         `json:"name"`
+	Errors  int            `json:"errors"`
+	Retries int            `json:"retries"`
+	Classes map[string]int `json:"classes"`
+}
+
+type intervalRecord struct {
+	ElapsedSeconds   float64        `json:"elapsed_seconds"`
+	Queries          int            `json:"queries"`
+	QueriesPerSecond float64        `json:"queries_per_second"`
+	Errors           int            `json:"errors"`
+	LatencyMs        *latencyRecord `json:"latency_ms,omitempty"`
+}
+
+type lagRecord struct {
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	Replica        string  `json:"replica"`
+	// SecondsBehindMaster is null when replication is not running.
+	SecondsBehindMaster *int64  `json:"seconds_behind_master"`
+	WritesPerSecond     float64 `json:"writes_per_second"`
+	ReadsPerSecond      float64 `json:"reads_per_second"`
+}
+
+type statusRecord struct {
+	Test   string `json:"test"`
+	Server string `json:"server"`
+	Status string `json:"status"`
+	Before int64  `json:"before"`
+	After  int64  `json:"after"`
+	Delta  int64  `json:"delta"`
+	// PerSecond is null for gauges like Threads_connected.
+	PerSecond *float64 `json:"per_second"`
+}
+
+type statusIntervalRecord struct {
+	ElapsedSeconds float64          `json:"elapsed_seconds"`
+	Server         string           `json:"server"`
+	Deltas         map[string]int64 `json:"deltas"`
+}
+
+// replayRecord compares the replayed latency of a query class with the
+// Query_time of the log, see --replay.
+type replayRecord struct {
+	Query             string         `json:"query"`
+	Count             int            `json:"count"`
+	OriginalLatencyMs *latencyRecord `json:"original_latency_ms,omitempty"`
+	LatencyMs         *latencyRecord `json:"latency_ms,omitempty"`
+}
+
+// bulkRecord holds the write throughput of a bulk step, see
+// WorkloadStep.Bulk.
+type bulkRecord struct {
+	Name           string  `json:"name"`
+	Mode           string  `json:"mode"`
+	Batch          int     `json:"batch"`
+	Statements     int     `json:"statements"`
+	Rows           int64   `json:"rows"`
+	RowsPerSecond  float64 `json:"rows_per_second"`
+	Bytes          int64   `json:"bytes"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	Errors         int     `json:"errors"`
+}
+
+// ddlRecord holds the run of a DDL step and the queries of the other steps
+// before, during and after it.
+type ddlRecord struct {
+	Name       string  `json:"name"`
+	Statement  string  `json:"statement"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+	// ThroughputChangePct is the change in queries per second during the
+	// DDL from before it.
+	ThroughputChangePct *float64         `json:"throughput_change_pct,omitempty"`
+	Before              *ddlWindowRecord `json:"before,omitempty"`
+	During              *ddlWindowRecord `json:"during,omitempty"`
+	After               *ddlWindowRecord `json:"after,omitempty"`
+	MDLWaiters          int              `json:"mdl_waiters"`
+	MDLWaitMs           float64          `json:"mdl_wait_ms"`
+	DDLMDLWaitMs        float64          `json:"ddl_mdl_wait_ms"`
+}
+
+type ddlWindowRecord struct {
+	Seconds          float64        `json:"seconds"`
+	Queries          int            `json:"queries"`
+	QueriesPerSecond float64        `json:"queries_per_second"`
+	Errors           int            `json:"errors"`
+	LatencyMs        *latencyRecord `json:"latency_ms,omitempty"`
+}
+
+type variableRecord struct {
+	Server   string `json:"server"`
+	Variable string `json:"variable"`
+	Value    string `json:"value"`
+}
+
+// milliseconds converts a duration to fractional milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// newLatencyRecord converts a histogram, which may be nil or empty.
+func newLatencyRecord(latency *histogram) *latencyRecord {
+	if latency == nil || latency.count == 0 {
+		return nil
+	}
+	return &latencyRecord{
+		Count: latency.count,
+		Min:   milliseconds(latency.min),
+		Mean:  milliseconds(latency.mean()),
+		P50:   milliseconds(latency.percentile(50)),
+		P90:   milliseconds(latency.percentile(90)),
+		P99:   milliseconds(latency.percentile(99)),
+		P999:  milliseconds(latency.percentile(99.9)),
+		Max:   milliseconds(latency.max),
+	}
+}
+
+// newResultRecord is the JSON form of a report row, see collectResult.
+func newResultRecord(name string, elapsed time.Duration, iterations int, memAllocs string, memBytes string, stats *stepStats) resultRecord {
+	record := resultRecord{Name: name, ElapsedSeconds: elapsed.Seconds(), Iterations: iterations}
+	if elapsed > 0 {
+		record.QueriesPerSecond = float64(iterations) / elapsed.Seconds()
+	}
+	if n, err := strconv.ParseUint(memAllocs, 10, 64); err == nil {
+		record.MemAllocs = &n
+	}
+	if n, err := strconv.ParseUint(memBytes, 10, 64); err == nil {
+		record.MemBytes = &n
+	}
+	if stats != nil {
+		record.Rows, record.Bytes, record.Errors, record.Retries = stats.rows, stats.bytes, stats.errors, stats.retries
+		record.LatencyMs = newLatencyRecord(stats.latency)
+	}
+	return record
+}
+
+func newTransactionRecord(name string, stats *stepStats) transactionRecord {
+	return transactionRecord{
+		Name:         name,
+		Transactions: stats.iterations,
+		Commits:      stats.commits,
+		Rollbacks:    stats.rollbacks,
+		Failed:       stats.errors,
+		Deadlocks:    stats.failures[errorDeadlock],
+		LockWaits:    stats.failures[errorLockWaitTimeout],
+		Retries:      stats.retries,
+		LatencyMs:    newLatencyRecord(stats.latency),
+	}
+}
+
+func newBulkRecord(name string, step *WorkloadStep, elapsed time.Duration, stats *stepStats) bulkRecord {
+	record := bulkRecord{Name: name, Mode: step.Bulk, Batch: step.Batch, Statements: stats.iterations, Rows: stats.rows, Bytes: stats.bytes, Errors: stats.errors}
+	if elapsed > 0 {
+		record.RowsPerSecond = float64(stats.rows) / elapsed.Seconds()
+		record.BytesPerSecond = float64(stats.bytes) / elapsed.Seconds()
+	}
+	return record
+}
+
+func newDDLRecord(name string, change *schemaChange) ddlRecord {
+	record := ddlRecord{Name: name, Statement: change.step.query, DurationMs: milliseconds(change.end.Sub(change.start)), MDLWaiters: change.waiters,
+		MDLWaitMs: milliseconds(change.waited), DDLMDLWaitMs: milliseconds(change.ddlWaited)}
+	if change.err != nil {
+		record.Error = change.err.Error()
+	}
+	windows := make([]*ddlWindowRecord, len(change.windows))
+	for i, window := range change.windows {
+		if window.length > 0 {
+			windows[i] = &ddlWindowRecord{Seconds: window.length.Seconds(), Queries: window.stats.iterations, QueriesPerSecond: window.rate(), Errors: window.stats.errors,
+				LatencyMs: newLatencyRecord(window.stats.latency)}
+		}
+	}
+	record.Before, record.During, record.After = windows[0], windows[1], windows[2]
+	if dip, ok := change.throughputChange(); ok {
+		record.ThroughputChangePct = &dip
+	}
+	return record
+}
+
+func newErrorRecord(name string, stats *stepStats) errorRecord {
+	record := errorRecord{Name: name, Errors: stats.errors, Retries: stats.retries, Classes: make(map[string]int)}
+	for class, count := range stats.failures {
+		record.Classes[errorClassNames[class]] = count
+	}
+	return record
+}
+
+// runMode returns the kind of run lomax was started for.
+func runMode() string {
+	switch {
+	case workloadFile != "":
+		return "workload"
+	case testVectorConfig != "":
+		return "vector"
+	case replayFile != "":
+		return "replay"
+	case isConnect():
+		return "connect"
+	}
+	return "benchmark"
+}
+
+// serverVersions returns the servers of the run and their MySQL versions.
+func serverVersions() []serverRecord {
+	var servers []serverRecord
+	for _, t := range configuredTargets() {
+		server := serverRecord{Address: t.name(), Role: t.role}
+		if t.host == "" {
+			server.Address = ""
+		}
+		db := initializeDB(USER, PASSWORD, t.host, t.port, "")
+		if err := db.QueryRow("SELECT VERSION()").Scan(&server.MySQLVersion); err != nil {
+			log.Warning(fmt.Sprintf("[%s]: %s: %s", GetFunctionName(serverVersions), server.Address, err.Error()))
+		}
+		db.Close()
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+// runConfig returns the effective options of the run, without the password.
+func runConfig() map[string]string {
+	config := make(map[string]string)
+	for _, s := range effectiveConfig {
+		config[s.name] = maskSecret(s.name, s.value)
+	}
+	return config
+}
+
+// finishResults fills in the run metadata of the result document.
+func finishResults() {
+	client, _ := os.Hostname()
+	resultDoc.Run = runMetadata{
+		LomaxVersion: version,
+		Client:       client,
+		Mode:         runMode(),
+		Workload:     workloadFile,
+		Vector:       testVectorConfig,
+		Replay:       replayFile,
+		Start:        runStart,
+		End:          time.Now(),
+		Servers:      serverVersions(),
+		Config:       runConfig(),
+	}
+	sort.Slice(resultDoc.Run.Servers, func(i, j int) bool { return resultDoc.Run.Servers[i].Address < resultDoc.Run.Servers[j].Address })
+}
+
+// writeResults writes the result document as indented JSON.
+func writeResults(file *os.File) error {
+	finishResults()
+	data, err := json.MarshalIndent(resultDoc, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
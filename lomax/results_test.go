@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewResultRecord(t *testing.T) {
+	stats := newStepStats(1)[0]
+	stats.rows, stats.errors, stats.retries = 40, 1, 2
+	stats.latency.record(2 * time.Millisecond)
+	stats.latency.record(4 * time.Millisecond)
+
+	record := newResultRecord("[1] Hire", 2*time.Second, 100, "1234", "-", &stats)
+	if record.ElapsedSeconds != 2 || record.QueriesPerSecond != 50 || record.Rows != 40 || record.Errors != 1 || record.Retries != 2 {
+		t.Errorf("newResultRecord() = %+v", record)
+	}
+	if record.MemAllocs == nil || *record.MemAllocs != 1234 || record.MemBytes != nil {
+		t.Errorf("newResultRecord() memory = %v, %v, want 1234 and none", record.MemAllocs, record.MemBytes)
+	}
+	if l := record.LatencyMs; l == nil || l.Count != 2 || l.Min < 1.9 || l.Max > 4.1 {
+		t.Errorf("newResultRecord() latency = %+v", l)
+	}
+
+	if record := newResultRecord("main.benchmark", time.Second, 10, "-", "-", nil); record.LatencyMs != nil {
+		t.Errorf("newResultRecord() without stats has latency %+v", record.LatencyMs)
+	}
+}
+
+func TestResultDocumentRoundTrip(t *testing.T) {
+	doc := resultDocument{Schema: resultSchema, Version: resultSchemaVersion}
+	doc.Results = append(doc.Results, resultRecord{Name: "[1] Hire", ElapsedSeconds: 1.5, Iterations: 300, LatencyMs: &latencyRecord{P99: 2.5}})
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded resultDocument
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	results, order, err := documentResults(decoded)
+	if err != nil {
+		t.Fatalf("documentResults() returned error %v", err)
+	}
+	r := results["[1] Hire"]
+	if len(order) != 1 || r.throughput() != 200 || r.latency["P99"] != 2500*time.Microsecond {
+		t.Errorf("documentResults() = %q, %+v", order, r)
+	}
+
+	decoded.Version = resultSchemaVersion + 1
+	if _, _, err := documentResults(decoded); err == nil {
+		t.Errorf("documentResults() accepted schema version %d", decoded.Version)
+	}
+}
+
+func TestRunConfig(t *testing.T) {
+	saved := effectiveConfig
+	defer func() { effectiveConfig = saved }()
+	effectiveConfig = []setting{{name: "password", value: "secret"}, {name: "threads", value: "8"},
+		{name: "dsn", value: "bench:secret@tcp(db1:3306)/employees?tls=skip-verify"}}
+
+	config := runConfig()
+	if config["password"] != "********" || config["threads"] != "8" {
+		t.Errorf("runConfig() = %v", config)
+	}
+	if strings.Contains(config["dsn"], "secret") || !strings.HasPrefix(config["dsn"], "bench@tcp(db1:3306)/employees?") {
+		t.Errorf("runConfig() dsn = %q, want it without the password", config["dsn"])
+	}
+
+	effectiveConfig = []setting{{name: "dsn", value: "bench:secret@tcp(db1"}}
+	if config := runConfig(); config["dsn"] != "********" {
+		t.Errorf("runConfig() of an invalid dsn = %q, want it masked", config["dsn"])
+	}
+}
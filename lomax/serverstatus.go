@@ -0,0 +1,285 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opendns/lemming/lib/log"
+)
+
+var serverStatusPtr, intervalStatusPtr bool
+var statusCountersPtr, statusVariablesPtr string
+
+// statusGauges are the status variables that hold a current value instead
+// of a counter, so their rate is meaningless.
+var statusGauges = map[string]bool{"Threads_connected": true, "Threads_running": true, "Open_tables": true, "Innodb_buffer_pool_pages_free": true}
+
+// statusBuffer holds the server status report, one row per test, server and
+// status variable.  variablesBuffer holds the global variables of every
+// server, statusIntervalBuffer the status of every server at every interval.
+var statusBuffer [][]string
+var statusHeader = []string{"Test", "Server", "Status", "Before", "After", "Delta", "Per Second"}
+var variablesBuffer [][]string
+var variablesHeader = []string{"Server", "Variable", "Value"}
+var statusIntervalBuffer [][]string
+var statusIntervalHeader = []string{"Elapsed", "Server", "Status", "Delta", "Per Second"}
+
+func init() {
+	flag.BoolVar(&serverStatusPtr, "serverstatus", true, "Snapshot SHOW GLOBAL STATUS before and after every test and report the difference.")
+	flag.BoolVar(&intervalStatusPtr, "intervalstatus", false, "With --interval, also report the server status differences of every interval.")
+	flag.StringVar(&statusCountersPtr, "statuscounters", "Com_select,Com_insert,Com_update,Com_delete,Questions,Innodb_rows_read,Innodb_rows_inserted,Innodb_rows_updated,Innodb_rows_deleted,Innodb_buffer_pool_read_requests,Innodb_buffer_pool_reads,Innodb_row_lock_waits,Innodb_row_lock_time,Created_tmp_tables,Created_tmp_disk_tables,Select_scan,Sort_merge_passes,Slow_queries,Threads_connected,Threads_running", "The SHOW GLOBAL STATUS variables --serverstatus reports.")
+	flag.StringVar(&statusVariablesPtr, "statusvariables", "version,innodb_buffer_pool_size,innodb_flush_log_at_trx_commit,sync_binlog,max_connections,long_query_time,transaction_isolation", "The SHOW GLOBAL VARIABLES --serverstatus records for every server.")
+}
+
+// statusNames returns the names in a comma separated list.
+func statusNames(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// validateServerStatus returns the problems of the --serverstatus options.
+func validateServerStatus() []string {
+	var problems []string
+	if intervalStatusPtr && (!serverStatusPtr || intervalPtr <= 0) {
+		problems = append(problems, "Please specify --serverstatus and an --interval to report the --intervalstatus.")
+	}
+	if serverStatusPtr && len(statusNames(statusCountersPtr)) == 0 {
+		problems = append(problems, "Please specify the --statuscounters to report, or --serverstatus=false.")
+	}
+	return problems
+}
+
+// statusSnapshot holds the numeric values of the selected status variables
+// at a point in time, keyed by their lower case name.
+type statusSnapshot struct {
+	at     time.Time
+	values map[string]int64
+}
+
+// readStatus takes a snapshot of the selected status variables of a server.
+func readStatus(db *sql.DB) (statusSnapshot, error) {
+	snapshot := statusSnapshot{at: time.Now(), values: make(map[string]int64)}
+	selected := make(map[string]bool)
+	for _, name := range statusNames(statusCountersPtr) {
+		selected[strings.ToLower(name)] = true
+	}
+	rows, err := db.Query("SHOW GLOBAL STATUS")
+	if err != nil {
+		return snapshot, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return snapshot, err
+		}
+		if !selected[strings.ToLower(name)] {
+			continue
+		}
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			snapshot.values[strings.ToLower(name)] = n
+		}
+	}
+	return snapshot, rows.Err()
+}
+
+// readVariables returns the selected global variables of a server.
+func readVariables(db *sql.DB) ([][2]string, error) {
+	names := statusNames(statusVariablesPtr)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+	rows, err := db.Query("SHOW GLOBAL VARIABLES WHERE Variable_name IN ("+placeholders+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var variables [][2]string
+	for rows.Next() {
+		var variable [2]string
+		if err := rows.Scan(&variable[0], &variable[1]); err != nil {
+			return nil, err
+		}
+		variables = append(variables, variable)
+	}
+	return variables, rows.Err()
+}
+
+// statusDelta holds the change of every selected status variable between
+// two snapshots.
+type statusDelta struct {
+	name          string
+	before, after int64
+	delta         int64
+	// perSecond is negative for gauges.
+	perSecond float64
+}
+
+// diffStatus returns the changes between two snapshots, in the order the
+// status variables were selected.
+func diffStatus(before statusSnapshot, after statusSnapshot) []statusDelta {
+	var deltas []statusDelta
+	seconds := after.at.Sub(before.at).Seconds()
+	for _, name := range statusNames(statusCountersPtr) {
+		key := strings.ToLower(name)
+		old, ok1 := before.values[key]
+		current, ok2 := after.values[key]
+		if !ok1 || !ok2 {
+			continue
+		}
+		delta := statusDelta{name: name, before: old, after: current, delta: current - old, perSecond: -1}
+		if !statusGauges[name] && seconds > 0 {
+			delta.perSecond = float64(delta.delta) / seconds
+		}
+		deltas = append(deltas, delta)
+	}
+	return deltas
+}
+
+// formatRate formats a per second rate, which is negative for gauges.
+func (delta statusDelta) formatRate() string {
+	if delta.perSecond < 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", delta.perSecond)
+}
+
+// statusServer is a server whose status is sampled.
+type statusServer struct {
+	name   string
+	db     *sql.DB
+	before statusSnapshot
+	last   statusSnapshot
+}
+
+// statusProbe samples the status of the servers of a test.
+type statusProbe struct {
+	test    string
+	servers []*statusServer
+}
+
+// statusConns holds the connection to every server status is sampled on,
+// keyed by the server's address.
+var statusConns = make(map[string]*sql.DB)
+var statusConnsMu sync.Mutex
+
+// statusConn returns the connection used to sample the status of a target.
+// The global variables of the server are recorded when it is first
+// connected to.
+func statusConn(t target) (*sql.DB, error) {
+	statusConnsMu.Lock()
+	defer statusConnsMu.Unlock()
+	if db := statusConns[t.name()]; db != nil {
+		return db, nil
+	}
+	db := initializeDB(USER, PASSWORD, t.host, t.port, "")
+	db.SetMaxOpenConns(1)
+	variables, err := readVariables(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, variable := range variables {
+		variablesBuffer = append(variablesBuffer, []string{t.name(), variable[0], variable[1]})
+		resultDoc.ServerVariables = append(resultDoc.ServerVariables, variableRecord{Server: t.name(), Variable: variable[0], Value: variable[1]})
+	}
+	statusConns[t.name()] = db
+	return db, nil
+}
+
+// closeStatusConns closes the connections opened by statusConn.
+func closeStatusConns() {
+	statusConnsMu.Lock()
+	defer statusConnsMu.Unlock()
+	for name, db := range statusConns {
+		db.Close()
+		delete(statusConns, name)
+	}
+}
+
+// startStatusProbe snapshots the status of the targets before a test.  It
+// returns nil, and samples nothing, unless --serverstatus is set.  Servers
+// whose status can not be read are left out with a warning.
+func startStatusProbe(test string, targets []target) *statusProbe {
+	if !serverStatusPtr {
+		return nil
+	}
+	probe := &statusProbe{test: test}
+	for _, t := range targets {
+		db, err := statusConn(t)
+		if err == nil {
+			server := &statusServer{name: t.name(), db: db}
+			if server.before, err = readStatus(db); err == nil {
+				server.last = server.before
+				probe.servers = append(probe.servers, server)
+				continue
+			}
+		}
+		log.Warning(fmt.Sprintf("[%s]: %s: %s, not reporting its status", GetFunctionName(startStatusProbe), t.name(), err.Error()))
+	}
+	return probe
+}
+
+// sample reports the status changes of every server since the last sample.
+// It is safe to call on a nil probe.
+func (probe *statusProbe) sample(elapsed time.Duration) {
+	if probe == nil {
+		return
+	}
+	for _, server := range probe.servers {
+		snapshot, err := readStatus(server.db)
+		if err != nil {
+			continue
+		}
+		var printed []string
+		record := statusIntervalRecord{ElapsedSeconds: elapsed.Seconds(), Server: server.name, Deltas: make(map[string]int64)}
+		for _, delta := range diffStatus(server.last, snapshot) {
+			printed = append(printed, fmt.Sprintf("%s %+d", delta.name, delta.delta))
+			record.Deltas[delta.name] = delta.delta
+			statusIntervalBuffer = append(statusIntervalBuffer, []string{fmt.Sprintf("%s", elapsed), server.name, delta.name, fmt.Sprintf("%d", delta.delta), delta.formatRate()})
+		}
+		resultDoc.StatusIntervals = append(resultDoc.StatusIntervals, record)
+		server.last = snapshot
+		fmt.Println(fmt.Sprintf("[%8s] %s: %s", elapsed, server.name, strings.Join(printed, ", ")))
+	}
+}
+
+// finish snapshots the status of the servers after the test and adds the
+// changes to the report.  It is safe to call on a nil probe.
+func (probe *statusProbe) finish() {
+	if probe == nil {
+		return
+	}
+	for _, server := range probe.servers {
+		after, err := readStatus(server.db)
+		if err != nil {
+			log.Warning(fmt.Sprintf("[%s]: %s: %s", GetFunctionName((*statusProbe).finish), server.name, err.Error()))
+			continue
+		}
+		for _, delta := range diffStatus(server.before, after) {
+			statusBuffer = append(statusBuffer, []string{probe.test, server.name, delta.name,
+				fmt.Sprintf("%d", delta.before), fmt.Sprintf("%d", delta.after), fmt.Sprintf("%+d", delta.delta), delta.formatRate()})
+			record := statusRecord{Test: probe.test, Server: server.name, Status: delta.name, Before: delta.before, After: delta.after, Delta: delta.delta}
+			if delta.perSecond >= 0 {
+				perSecond := delta.perSecond
+				record.PerSecond = &perSecond
+			}
+			resultDoc.ServerStatus = append(resultDoc.ServerStatus, record)
+		}
+	}
+}
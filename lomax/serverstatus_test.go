@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStatusNames(t *testing.T) {
+	want := []string{"Com_select", "Slow_queries"}
+	if got := statusNames(" Com_select,, Slow_queries ,"); !reflect.DeepEqual(got, want) {
+		t.Errorf("statusNames() = %q, want %q", got, want)
+	}
+}
+
+func TestDiffStatus(t *testing.T) {
+	counters := statusCountersPtr
+	defer func() { statusCountersPtr = counters }()
+	statusCountersPtr = "Com_select,Threads_connected,Slow_queries,Innodb_rows_read"
+
+	start := time.Now()
+	before := statusSnapshot{at: start, values: map[string]int64{"com_select": 100, "threads_connected": 4, "slow_queries": 2}}
+	after := statusSnapshot{at: start.Add(2 * time.Second), values: map[string]int64{"com_select": 300, "threads_connected": 12, "slow_queries": 2, "innodb_rows_read": 7}}
+
+	deltas := diffStatus(before, after)
+	if len(deltas) != 3 {
+		t.Fatalf("diffStatus() = %+v, want 3 deltas", deltas)
+	}
+	if d := deltas[0]; d.name != "Com_select" || d.delta != 200 || d.formatRate() != "100.0" {
+		t.Errorf("Com_select delta = %+v, want +200 at 100.0/sec", d)
+	}
+	if d := deltas[1]; d.name != "Threads_connected" || d.delta != 8 || d.formatRate() != "-" {
+		t.Errorf("Threads_connected delta = %+v, want +8 without a rate", d)
+	}
+	if d := deltas[2]; d.name != "Slow_queries" || d.delta != 0 || d.formatRate() != "0.0" {
+		t.Errorf("Slow_queries delta = %+v, want 0 at 0.0/sec", d)
+	}
+}
+
+func TestValidateServerStatus(t *testing.T) {
+	status, interval, every, counters := serverStatusPtr, intervalStatusPtr, intervalPtr, statusCountersPtr
+	defer func() {
+		serverStatusPtr, intervalStatusPtr, intervalPtr, statusCountersPtr = status, interval, every, counters
+	}()
+
+	serverStatusPtr, intervalStatusPtr, intervalPtr, statusCountersPtr = true, true, time.Second, "Questions"
+	if problems := validateServerStatus(); len(problems) != 0 {
+		t.Errorf("validateServerStatus() = %q, want no problems", problems)
+	}
+	intervalPtr, statusCountersPtr = 0, ""
+	if problems := validateServerStatus(); len(problems) != 2 {
+		t.Errorf("validateServerStatus() = %q, want 2 problems", problems)
+	}
+}
+
+func TestStatusProbeDisabled(t *testing.T) {
+	status := serverStatusPtr
+	defer func() { serverStatusPtr = status }()
+	serverStatusPtr = false
+
+	probe := startStatusProbe("test", []target{{host: "db1", port: "3306"}})
+	if probe != nil {
+		t.Fatalf("startStatusProbe() with --serverstatus=false = %+v, want nil", probe)
+	}
+	// A nil probe samples nothing.
+	probe.sample(time.Second)
+	probe.finish()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var targetsPtr string
+var fanoutPtr bool
+
+func init() {
+	flag.StringVar(&targetsPtr, "targets", "", "Servers to benchmark: a list of [role=]host[:port], e.g. primary=db1:3306,replica=db2,replica=db3. Workload steps with a \"role\" run on the targets of that role.")
+	flag.BoolVar(&fanoutPtr, "fanout", false, "Run the workload against every one of the --targets at the same time and report every target and their aggregate.")
+}
+
+// target is one of the servers given with --targets.
+type target struct {
+	role string
+	host string
+	port string
+}
+
+// name returns the host:port of the target.
+func (t target) name() string {
+	return tcpAddress(t.host, t.port)
+}
+
+// parseTargets parses a --targets list.  Targets without a port use --port.
+func parseTargets(spec string) ([]target, error) {
+	var targets []target
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		t := target{}
+		if i := strings.Index(entry, "="); i >= 0 {
+			t.role, entry = strings.ToLower(strings.TrimSpace(entry[:i])), strings.TrimSpace(entry[i+1:])
+			if t.role == "" {
+				return nil, fmt.Errorf("empty role in target %q", entry)
+			}
+		}
+		host, port, err := net.SplitHostPort(entry)
+		if err != nil {
+			// No port, or an IPv6 address without brackets.
+			host, port = strings.Trim(entry, "[]"), portPtr
+		}
+		if host == "" {
+			return nil, fmt.Errorf("no host in target %q", entry)
+		}
+		t.host, t.port = host, port
+		if seen[t.name()] {
+			return nil, fmt.Errorf("duplicate target %s", t.name())
+		}
+		seen[t.name()] = true
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets in %q", spec)
+	}
+	return targets, nil
+}
+
+// configuredTargets returns the --targets, or the single server given with
+// --hostname and --port.
+func configuredTargets() []target {
+	if targetsPtr == "" {
+		return []target{{host: hostNamePtr, port: portPtr}}
+	}
+	targets, _ := parseTargets(targetsPtr)
+	return targets
+}
+
+// useFirstTarget makes the first of the --targets the server that lomax
+// connects to outside of workloads, unless --hostname is given.
+func useFirstTarget() {
+	if targetsPtr == "" || hostNamePtr != "" {
+		return
+	}
+	if targets, err := parseTargets(targetsPtr); err == nil {
+		hostNamePtr, portPtr = targets[0].host, targets[0].port
+	}
+}
+
+// validateTargets returns the problems of the --targets and --fanout
+// options.
+func validateTargets() []string {
+	var problems []string
+	if targetsPtr != "" {
+		if _, err := parseTargets(targetsPtr); err != nil {
+			problems = append(problems, fmt.Sprintf("Please specify valid --targets: %s", err.Error()))
+		}
+	}
+	if fanoutPtr && (targetsPtr == "" || workloadFile == "") {
+		problems = append(problems, "Please specify --targets and a --workload to --fanout.")
+	}
+	return problems
+}
+
+// checkRoles returns the problems of routing the steps of a workload to
+// targets: every step role needs a target, and a fan-out runs every step on
+// every target.
+func checkRoles(steps []WorkloadStep, targets []target) []string {
+	roles := make(map[string]bool)
+	for _, t := range targets {
+		roles[t.role] = true
+	}
+	var problems []string
+	for i := range steps {
+		role := steps[i].Role
+		switch {
+		case role == "":
+		case fanoutPtr:
+			problems = append(problems, fmt.Sprintf("%s: a --fanout runs every step on every target, remove its role %q", steps[i].label(i), role))
+		case !roles[role]:
+			problems = append(problems, fmt.Sprintf("%s: no --targets with role %q", steps[i].label(i), role))
+		}
+	}
+	return problems
+}
+
+// roleTargets returns the targets of every role.
+func roleTargets(targets []target) map[string][]target {
+	byRole := make(map[string][]target)
+	for _, t := range targets {
+		if t.role != "" {
+			byRole[t.role] = append(byRole[t.role], t)
+		}
+	}
+	return byRole
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestParseTargets(t *testing.T) {
+	port := portPtr
+	defer func() { portPtr = port }()
+	portPtr = "3307"
+
+	targets, err := parseTargets("primary=db1:3306, replica=db2,replica=[::1]:3308,shard4")
+	if err != nil {
+		t.Fatalf("parseTargets() returned error %v", err)
+	}
+	want := []target{
+		{role: "primary", host: "db1", port: "3306"},
+		{role: "replica", host: "db2", port: "3307"},
+		{role: "replica", host: "::1", port: "3308"},
+		{host: "shard4", port: "3307"},
+	}
+	if !reflect.DeepEqual(targets, want) {
+		t.Errorf("parseTargets() = %+v, want %+v", targets, want)
+	}
+	if name := targets[2].name(); name != "[::1]:3308" {
+		t.Errorf("name() = %q, want [::1]:3308", name)
+	}
+
+	for _, spec := range []string{"", "=db1", "db1:3306,db1:3306", "primary=:3306"} {
+		if _, err := parseTargets(spec); err == nil {
+			t.Errorf("parseTargets(%q) returned no error", spec)
+		}
+	}
+}
+
+func TestCheckRoles(t *testing.T) {
+	fanout := fanoutPtr
+	defer func() { fanoutPtr = fanout }()
+	fanoutPtr = false
+
+	targets := []target{{role: "primary", host: "db1"}, {role: "replica", host: "db2"}}
+	steps := []WorkloadStep{{Action: "INSERT", Role: "primary"}, {Action: "SELECT", Role: "replica"}, {Action: "SELECT"}}
+	if problems := checkRoles(steps, targets); len(problems) != 0 {
+		t.Errorf("checkRoles() = %q, want no problems", problems)
+	}
+	steps = append(steps, WorkloadStep{Action: "SELECT", Role: "analytics"})
+	if problems := checkRoles(steps, targets); len(problems) != 1 {
+		t.Errorf("checkRoles() with an unknown role = %q, want 1 problem", problems)
+	}
+
+	fanoutPtr = true
+	if problems := checkRoles(steps[:2], targets); len(problems) != 2 {
+		t.Errorf("checkRoles() of a fan-out = %q, want 2 problems", problems)
+	}
+}
+
+func TestDBFor(t *testing.T) {
+	primary, replica := &sql.DB{}, &sql.DB{}
+	w := newWorker(0, primary, 1)
+	w.roles = map[string]*sql.DB{"replica": replica}
+
+	cases := []struct {
+		role string
+		want *sql.DB
+	}{
+		{"", primary},
+		{"replica", replica},
+		{"unknown", primary},
+	}
+	for _, c := range cases {
+		if got := w.dbFor(&WorkloadStep{Role: c.role}); got != c.want {
+			t.Errorf("dbFor(%q) returned the wrong connection", c.role)
+		}
+	}
+}
+
+func TestTransactionRole(t *testing.T) {
+	workload, err := parseWorkload([]byte(`{"steps": [{"role": "primary", "transaction": {"statements": [{"action": "SELECT", "table": "salaries"}]}}]}`))
+	if err != nil {
+		t.Fatalf("parseWorkload() returned error %v", err)
+	}
+	if role := workload.Steps[0].Transaction.Statements[0].Role; role != "primary" {
+		t.Errorf("transaction statement role = %q, want primary", role)
+	}
+
+	_, err = parseWorkload([]byte(`{"steps": [{"transaction": {"statements": [{"action": "SELECT", "table": "salaries", "role": "replica"}]}}]}`))
+	if err == nil {
+		t.Errorf("parseWorkload() accepted a role on a transaction statement")
+	}
+}
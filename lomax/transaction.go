@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Transaction is a group of statements a workload step runs between BEGIN
+// and COMMIT.  The statements are regular workload steps, every one of them
+// runs count times in a row.
+//
+// Example:
+//
+//	{"name": "Transfer", "transaction": {
+//	  "isolation": "repeatable read",
+//	  "rollback_ratio": 0.1,
+//	  "statements": [
+//	    {"query": "UPDATE salaries SET salary = salary - 100 WHERE emp_no = ?", "params": [{"type": "uniform", "min": 10001, "max": 10100}]},
+//	    {"query": "UPDATE salaries SET salary = salary + 100 WHERE emp_no = ?", "params": [{"type": "uniform", "min": 10001, "max": 10100}]}
+//	  ]
+//	}}
+type Transaction struct {
+	// Isolation sets the isolation level of the transaction, the server's
+	// default is used if it is empty.
+	Isolation string `json:"isolation"`
+	// RollbackRatio is the fraction of transactions that end with a
+	// ROLLBACK instead of a COMMIT.
+	RollbackRatio float64 `json:"rollback_ratio"`
+	// Retries is how often a transaction is retried after a deadlock or a
+	// lock wait timeout.  It defaults to 3, a negative value disables
+	// retrying.
+	Retries    int            `json:"retries"`
+	Statements []WorkloadStep `json:"statements"`
+}
+
+const (
+	actionTransaction = "TRANSACTION"
+
+	defaultTransactionRetries = 3
+)
+
+var isolationLevels = []string{"READ UNCOMMITTED", "READ COMMITTED", "REPEATABLE READ", "SERIALIZABLE"}
+
+// transactionBuffer holds the transaction report, one row per transaction
+// step.
+var transactionBuffer [][]string
+var transactionHeader = append([]string{"Transaction", "Transactions", "Commits", "Rollbacks", "Commit Rate", "Failed", "Deadlocks", "Lock Waits", "Retries"}, latencyHeader...)
+
+// validate checks the transaction of the step labeled label and fills in its
+// defaults.
+func (txn *Transaction) validate(label string) error {
+	if txn.Isolation != "" {
+		isolation := strings.ToUpper(strings.NewReplacer("_", " ", "-", " ").Replace(txn.Isolation))
+		valid := false
+		for _, level := range isolationLevels {
+			valid = valid || isolation == level
+		}
+		if !valid {
+			return fmt.Errorf("%s: invalid isolation level %q", label, txn.Isolation)
+		}
+		txn.Isolation = isolation
+	}
+	if txn.RollbackRatio < 0 || txn.RollbackRatio > 1 {
+		return fmt.Errorf("%s: rollback_ratio must be between 0 and 1", label)
+	}
+	if txn.Retries == 0 {
+		txn.Retries = defaultTransactionRetries
+	}
+	if len(txn.Statements) == 0 {
+		return fmt.Errorf("%s: transaction has no statements", label)
+	}
+	for i := range txn.Statements {
+		statement := &txn.Statements[i]
+		if statement.Transaction != nil {
+			return fmt.Errorf("%s.%d: transactions can not be nested", label, i+1)
+		}
+		if err := statement.validate(fmt.Sprintf("%s.%d", label, i+1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execTransactionAt runs a single iteration of a transaction step that was
+// scheduled to start at intended, retrying it after deadlocks and lock wait
+// timeouts after a backoff.  Other errors are handled according to
+// --onerror.  The statements are recorded in their own slots, the
+// transaction as a whole under index.
+func (w *worker) execTransactionAt(index int, step *WorkloadStep, intended time.Time) {
+	start := time.Now()
+	result := sample{}
+
+	for attempt := 0; ; attempt++ {
+		rolledBack, err := w.runTransaction(step)
+		if err == nil {
+			if rolledBack {
+				result.rollbacks++
+			} else {
+				result.commits++
+			}
+			break
+		}
+
+		result.failures[classifyError(err)]++
+		if !w.retryTransaction(step, err, attempt) {
+			result.failed = true
+			break
+		}
+		result.retries++
+	}
+
+	result.busy = time.Since(start)
+	result.latency = time.Since(intended)
+	w.recordTransaction(index, result)
+}
+
+// retryTransaction reports whether to retry a transaction of step that
+// failed with err after attempt retries.  Deadlocks and lock wait timeouts
+// are retried up to the transaction's own retries and never by --onerror,
+// other errors according to --onerror.
+func (w *worker) retryTransaction(step *WorkloadStep, err error, attempt int) bool {
+	if class := classifyError(err); class != errorDeadlock && class != errorLockWaitTimeout {
+		return w.retryError(step, err, attempt)
+	}
+	if attempt < step.Transaction.Retries {
+		w.backoff(attempt)
+		return true
+	}
+	w.failError(step, err)
+	return false
+}
+
+// runTransaction runs the statements of the transaction of step once.  The
+// transaction is rolled back if any of them fails, and the error is returned.
+func (w *worker) runTransaction(step *WorkloadStep) (rolledBack bool, err error) {
+	txn := step.Transaction
+	// SET TRANSACTION applies to the next transaction on the session, so
+	// the connection is pinned in case the workers share a pool.
+	ctx := context.Background()
+	conn, err := w.dbFor(step).Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if txn.Isolation != "" {
+		if _, err := conn.ExecContext(ctx, "SET TRANSACTION ISOLATION LEVEL "+txn.Isolation); err != nil {
+			return false, err
+		}
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range txn.Statements {
+		statement := &txn.Statements[i]
+		for n := 0; n < statement.Count; n++ {
+			start := time.Now()
+			result, err := w.exec(tx, statement.slot, statement)
+			result.busy = time.Since(start)
+			result.latency = result.busy
+			if err != nil {
+				result.failed = true
+				result.failures[classifyError(err)]++
+			}
+			w.record(statement.slot, result)
+			if err != nil {
+				tx.Rollback()
+				return false, err
+			}
+		}
+	}
+
+	if txn.RollbackRatio > 0 && w.rng.Float64() < txn.RollbackRatio {
+		return true, tx.Rollback()
+	}
+	return false, tx.Commit()
+}
+
+// collectTransaction adds the report row of a transaction step.
+func collectTransaction(name string, stats *stepStats) {
+	commitRate := "-"
+	if stats.iterations > 0 {
+		commitRate = fmt.Sprintf("%.1f%%", 100*float64(stats.commits)/float64(stats.iterations))
+	}
+	row := []string{name, fmt.Sprintf("%d", stats.iterations), fmt.Sprintf("%d", stats.commits), fmt.Sprintf("%d", stats.rollbacks), commitRate,
+		fmt.Sprintf("%d", stats.errors), fmt.Sprintf("%d", stats.failures[errorDeadlock]), fmt.Sprintf("%d", stats.failures[errorLockWaitTimeout]), fmt.Sprintf("%d", stats.retries)}
+	transactionBuffer = append(transactionBuffer, append(row, latencyColumns(stats.latency)...))
+	resultDoc.Transactions = append(resultDoc.Transactions, newTransactionRecord(name, stats))
+}
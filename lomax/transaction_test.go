@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestParseTransaction(t *testing.T) {
+	cases := []struct {
+		input     string
+		wantError bool
+	}{
+		{`{"transaction": {"isolation": "read_committed", "statements": [{"action": "SELECT", "table": "employees"}]}}`, false},
+		{`{"transaction": {"statements": []}}`, true},
+		{`{"transaction": {"isolation": "chaos", "statements": [{"action": "SELECT", "table": "employees"}]}}`, true},
+		{`{"transaction": {"rollback_ratio": 1.5, "statements": [{"action": "SELECT", "table": "employees"}]}}`, true},
+		{`{"transaction": {"statements": [{"action": "TRUNCATE", "table": "employees"}]}}`, true},
+		{`{"transaction": {"statements": [{"transaction": {"statements": [{"action": "SELECT", "table": "employees"}]}}]}}`, true},
+		{`{"table": "employees", "transaction": {"statements": [{"action": "SELECT", "table": "employees"}]}}`, true},
+	}
+	for _, c := range cases {
+		_, err := parseWorkload([]byte(`{"name": "txn", "steps": [` + c.input + `]}`))
+		if (err != nil) != c.wantError {
+			t.Errorf("parseWorkload(%s) returned error %v, want error %t", c.input, err, c.wantError)
+		}
+	}
+}
+
+func TestTransactionSlots(t *testing.T) {
+	workload, err := parseWorkload([]byte(`{"name": "txn", "steps": [
+		{"action": "SELECT", "table": "employees"},
+		{"transaction": {"isolation": "serializable", "statements": [{"action": "SELECT", "table": "employees"}, {"action": "DELETE", "table": "titles"}]}},
+		{"transaction": {"retries": -1, "statements": [{"action": "UPDATE", "table": "salaries", "condition": "salary = 1"}]}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if workload.slots != 6 {
+		t.Errorf("workload has %d slots, want 6", workload.slots)
+	}
+	txn := workload.Steps[1].Transaction
+	if txn.Statements[0].slot != 3 || txn.Statements[1].slot != 4 || workload.Steps[2].Transaction.Statements[0].slot != 5 {
+		t.Errorf("transaction statements got slots %d, %d, %d, want 3, 4, 5",
+			txn.Statements[0].slot, txn.Statements[1].slot, workload.Steps[2].Transaction.Statements[0].slot)
+	}
+	if workload.Steps[1].Action != actionTransaction || txn.Isolation != "SERIALIZABLE" || txn.Retries != defaultTransactionRetries {
+		t.Errorf("transaction defaults = %q, %q, %d retries", workload.Steps[1].Action, txn.Isolation, txn.Retries)
+	}
+	if workload.Steps[2].Transaction.Retries != -1 {
+		t.Errorf("retries = %d, want -1 to disable retrying", workload.Steps[2].Transaction.Retries)
+	}
+}
+
+func TestRecordTransaction(t *testing.T) {
+	w := newWorker(0, nil, 1)
+	deadlocks, lockWait := [errorClasses]int{}, [errorClasses]int{}
+	deadlocks[errorDeadlock] = 2
+	lockWait[errorLockWaitTimeout] = 1
+	w.recordTransaction(0, sample{latency: time.Millisecond, commits: 1, failures: deadlocks, retries: 2})
+	w.recordTransaction(0, sample{latency: time.Millisecond, failed: true, failures: lockWait})
+
+	stats := w.steps[0]
+	if stats.iterations != 2 || stats.commits != 1 || stats.errors != 1 || stats.failures[errorDeadlock] != 2 || stats.failures[errorLockWaitTimeout] != 1 || stats.retries != 2 {
+		t.Errorf("recorded transaction stats = %+v", stats)
+	}
+	if w.interval[0].iterations != 0 {
+		t.Errorf("transactions were counted in the interval statistics")
+	}
+
+	transactionBuffer = nil
+	collectTransaction("txn", &stats)
+	if row := transactionBuffer[0]; len(row) != len(transactionHeader) || row[4] != "50.0%" {
+		t.Errorf("collectTransaction() = %v, want %d columns and a 50.0%% commit rate", row, len(transactionHeader))
+	}
+	transactionBuffer = nil
+}
+
+func TestRetryTransaction(t *testing.T) {
+	defer func(policy string, retries int, backoff time.Duration) {
+		onErrorPtr, retriesPtr, backoffPtr = policy, retries, backoff
+	}(onErrorPtr, retriesPtr, backoffPtr)
+	onErrorPtr, retriesPtr, backoffPtr = onErrorRetry, 3, time.Millisecond
+
+	w := newWorker(0, nil, 1)
+	deadlock := &mysql.MySQLError{Number: errDeadlock, Message: "Deadlock found"}
+	lost := &mysql.MySQLError{Number: errServerGone, Message: "MySQL server has gone away"}
+
+	// A negative retries turns retrying deadlocks off, whatever --onerror
+	// says.
+	step := &WorkloadStep{Transaction: &Transaction{Retries: -1}}
+	if w.retryTransaction(step, deadlock, 0) {
+		t.Errorf("retries -1 retried a deadlock")
+	}
+	if w.lastErr != deadlock {
+		t.Errorf("retries -1 did not keep the deadlock")
+	}
+
+	// Deadlocks have a single budget: the transaction's retries.
+	step = &WorkloadStep{Transaction: &Transaction{Retries: 1}}
+	if !w.retryTransaction(step, deadlock, 0) || w.retryTransaction(step, deadlock, 1) {
+		t.Errorf("retries 1 did not retry a deadlock exactly once")
+	}
+
+	// Other transient errors are retried according to --onerror.
+	if !w.retryTransaction(step, lost, 1) || w.retryTransaction(step, lost, 3) {
+		t.Errorf("a lost connection was not retried --retries times")
+	}
+}
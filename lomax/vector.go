@@ -0,0 +1,256 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opendns/lemming/lib/log"
+)
+
+// TestCase is a single entry of a test vector file.
+type TestCase struct {
+	Name      string `json:"test_name"`
+	DB        string `json:"test_db"`
+	Table     string `json:"test_table"`
+	Action    string `json:"action"`
+	Flag      string `json:"flag"`
+	Columns   string `json:"columns"`
+	Condition string `json:"condition"`
+	Random    bool   `json:"random"`
+	Limit     int    `json:"limit"`
+	Count     int    `json:"count"`
+	// Test cases sharing the same group run in parallel.  Groups, and
+	// ungrouped test cases, run in the order they appear in the file.
+	Group string `json:"group"`
+}
+
+// Vector is a test vector file.  It either holds a "testcases" array or,
+// for older files, the keys of a single test case at the top level.
+type Vector struct {
+	TestCases []TestCase `json:"testcases"`
+	// Config sets options for the run, with the same keys as a --config
+	// file, e.g. {"threads": 8, "onerror": "retry"}.
+	Config map[string]json.RawMessage `json:"config"`
+
+	// source is the file the vector was loaded from.
+	source string
+}
+
+// vectorHeader is the report header of a test vector run.
+var vectorHeader = append(append([]string{"Test Name", "Result", "Time Taken", "Iterations"}, statsHeader...), "Error")
+
+// testCaseResult holds the outcome of running a single test case.
+type testCaseResult struct {
+	elapsed    time.Duration
+	iterations int
+	stats      *stepStats
+	err        string
+}
+
+// step converts a test case into the equivalent workload step.
+func (tc *TestCase) step() WorkloadStep {
+	condition := tc.Condition
+	if tc.Limit > 0 && strings.ToUpper(tc.Action) == "SELECT" {
+		condition = fmt.Sprintf("%s LIMIT %d", condition, tc.Limit)
+	}
+	return WorkloadStep{
+		Name:      tc.Name,
+		Action:    tc.Action,
+		Flag:      tc.Flag,
+		Table:     tc.Table,
+		Columns:   tc.Columns,
+		Condition: condition,
+		Random:    tc.Random,
+		Count:     tc.Count,
+	}
+}
+
+// parseVector decodes and validates a test vector file.
+func parseVector(data []byte) (*Vector, error) {
+	var vector Vector
+	if err := json.Unmarshal(data, &vector); err != nil {
+		return nil, err
+	}
+	if len(vector.TestCases) == 0 {
+		var single TestCase
+		if err := json.Unmarshal(data, &single); err != nil {
+			return nil, err
+		}
+		vector.TestCases = []TestCase{single}
+	}
+
+	if _, problems := newConfigLayer("config", vector.Config); len(problems) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(problems, ", "))
+	}
+
+	names := make(map[string]bool)
+	for i := range vector.TestCases {
+		tc := &vector.TestCases[i]
+		if tc.Name == "" {
+			tc.Name = fmt.Sprintf("[TestCase #%d]", i+1)
+		}
+		if names[tc.Name] {
+			return nil, fmt.Errorf("duplicate test_name %q", tc.Name)
+		}
+		names[tc.Name] = true
+
+		switch strings.ToUpper(tc.Action) {
+		case "SELECT", "INSERT", "UPDATE", "DELETE":
+		default:
+			return nil, fmt.Errorf("%s: invalid action %q", tc.Name, tc.Action)
+		}
+		if tc.Table == "" {
+			return nil, fmt.Errorf("%s: no test_table specified", tc.Name)
+		}
+		if tc.Count <= 0 {
+			tc.Count = 1
+		}
+	}
+	return &vector, nil
+}
+
+func loadVector(name string) *Vector {
+	path, err := resolvePath(name, searchDirs("testvectors"))
+	if err != nil {
+		log.Error(fmt.Sprintf("Test config File IO Error: %s\n", err.Error()))
+	}
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Error(fmt.Sprintf("Test config File IO Error: %s\n", err.Error()))
+	}
+
+	vector, err := parseVector(file)
+	if err != nil {
+		log.Error("[%s]: %s: %s", GetFunctionName(loadVector), name, err.Error())
+	}
+	vector.source = path
+	return vector
+}
+
+// configLayer returns the options the vector's config object sets.
+func (vector *Vector) configLayer() configLayer {
+	layer, _ := newConfigLayer(vector.source, vector.Config)
+	return layer
+}
+
+// vectorGroups splits the test cases into consecutive batches, where each
+// batch is either a single ungrouped test case or a run of adjacent test
+// cases of the same group.  A group whose test cases are not adjacent runs
+// as several batches, so that test cases always run in file order.
+func vectorGroups(testCases []TestCase) [][]int {
+	var batches [][]int
+	for i, tc := range testCases {
+		if tc.Group != "" && i > 0 && testCases[i-1].Group == tc.Group {
+			batches[len(batches)-1] = append(batches[len(batches)-1], i)
+			continue
+		}
+		batches = append(batches, []int{i})
+	}
+	return batches
+}
+
+// runTestCase runs every iteration of a test case.  A test case fails if any
+// iteration failed.  With --onerror=abort log.Error panics on the first
+// failure, so the panic is recovered and recorded as the failure.
+func runTestCase(db *sql.DB, tc *TestCase) (result testCaseResult) {
+	step := tc.step()
+	w := newWorker(0, db, 1)
+	start := time.Now()
+	defer func() {
+		result.elapsed = time.Since(start)
+		result.stats = &w.steps[0]
+		if r := recover(); r != nil {
+			result.err = strings.TrimSpace(fmt.Sprintf("%v", r))
+		} else if w.steps[0].errors > 0 {
+			result.err = fmt.Sprintf("%d failed: %s", w.steps[0].errors, w.lastErr.Error())
+		}
+	}()
+
+	step.prepare(db)
+	defer w.close()
+	for result.iterations < step.Count {
+		w.execStep(0, &step)
+		result.iterations++
+	}
+	return result
+}
+
+func runVector(vector *Vector) {
+	fmt.Println(fmt.Sprintf("Running %d test cases, please wait...", len(vector.TestCases)))
+
+	if logPrefix == "" {
+		log.Warning(fmt.Sprintf("[%s]: No --logprefix defined, log file will NOT be created", GetFunctionName(exportData)))
+	}
+
+	pools := make(map[string]*sql.DB)
+	for _, tc := range vector.TestCases {
+		db := tc.DB
+		if db == "" {
+			db = dbPtr
+		}
+		if _, ok := pools[db]; !ok {
+			pools[db] = initializeDB(USER, PASSWORD, hostNamePtr, portPtr, db)
+			defer pools[db].Close()
+		}
+	}
+	poolFor := func(tc *TestCase) *sql.DB {
+		if tc.DB == "" {
+			return pools[dbPtr]
+		}
+		return pools[tc.DB]
+	}
+
+	results := make([]testCaseResult, len(vector.TestCases))
+	start := time.Now()
+	servers := []target{{host: hostNamePtr, port: portPtr}}
+	for _, batch := range vectorGroups(vector.TestCases) {
+		// Test cases of a batch run at the same time, so the server status
+		// is only known for the whole batch.
+		var names []string
+		for _, i := range batch {
+			names = append(names, vector.TestCases[i].Name)
+		}
+		status := startStatusProbe(strings.Join(names, ", "), servers)
+		var wg sync.WaitGroup
+		for _, i := range batch {
+			fmt.Println(fmt.Sprintf("[%s]: Running %s", GetFunctionName(runVector), vector.TestCases[i].Name))
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = runTestCase(poolFor(&vector.TestCases[i]), &vector.TestCases[i])
+			}(i)
+		}
+		wg.Wait()
+		status.finish()
+	}
+	total := time.Since(start)
+
+	benchHeader = vectorHeader
+	passed, iterations := 0, 0
+	totalStats := newStepStats(1)[0]
+	for i, result := range results {
+		status := "FAIL"
+		if result.err == "" {
+			status = "PASS"
+			passed++
+		}
+		iterations += result.iterations
+		totalStats.add(result.stats)
+		row := append([]string{vector.TestCases[i].Name, status, fmt.Sprintf("%s", result.elapsed), fmt.Sprintf("%d", result.iterations)}, statsColumns(result.stats)...)
+		benchBuffer = append(benchBuffer, append(row, result.err))
+		record := newResultRecord(vector.TestCases[i].Name, result.elapsed, result.iterations, "-", "-", result.stats)
+		record.Result, record.Error = status, result.err
+		resultDoc.Results = append(resultDoc.Results, record)
+		collectErrors(vector.TestCases[i].Name, result.stats)
+	}
+	row := append([]string{"TOTAL", fmt.Sprintf("%d/%d PASS", passed, len(results)), fmt.Sprintf("%s", total), fmt.Sprintf("%d", iterations)}, statsColumns(&totalStats)...)
+	benchBuffer = append(benchBuffer, append(row, ""))
+	resultDoc.Results = append(resultDoc.Results, newResultRecord("TOTAL", total, iterations, "-", "-", &totalStats))
+	printData()
+	fmt.Println(fmt.Sprintf("%d test cases: %d passed, %d failed in %s", len(results), passed, len(results)-passed, total))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseVector(t *testing.T) {
+	cases := []struct {
+		input     string
+		wantCases int
+		wantError bool
+	}{
+		{`{"test_name": "flat", "test_table": "departments", "action": "SELECT", "columns": "*"}`, 1, false},
+		{`{"testcases": [{"test_name": "a", "test_table": "departments", "action": "SELECT"}, {"test_name": "b", "test_table": "departments", "action": "DELETE"}]}`, 2, false},
+		{`{"testcases": [{"test_name": "a", "test_table": "departments", "action": "SELECT"}, {"test_name": "a", "test_table": "departments", "action": "SELECT"}]}`, 0, true},
+		{`{"testcases": [{"test_name": "a", "test_table": "departments", "action": "INSERT INTO"}]}`, 0, true},
+		{`{"testcases": [{"test_name": "a", "action": "SELECT"}]}`, 0, true},
+	}
+	for _, c := range cases {
+		vector, err := parseVector([]byte(c.input))
+		if (err != nil) != c.wantError {
+			t.Errorf("parseVector(%s) returned error %v, want error %t", c.input, err, c.wantError)
+			continue
+		}
+		if err == nil && len(vector.TestCases) != c.wantCases {
+			t.Errorf("parseVector(%s) returned %d test cases, want %d", c.input, len(vector.TestCases), c.wantCases)
+		}
+	}
+}
+
+func TestVectorFiles(t *testing.T) {
+	files, err := filepath.Glob("./testvectors/json/*.json")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no test vector files found: %v", err)
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseVector(data); err != nil {
+			t.Errorf("%s: %s", file, err)
+		}
+	}
+}
+
+func TestVectorGroups(t *testing.T) {
+	testCases := []TestCase{{}, {Group: "a"}, {Group: "a"}, {}, {Group: "a"}, {Group: "b"}, {Group: "b"}, {}, {}}
+	want := [][]int{{0}, {1, 2}, {3}, {4}, {5, 6}, {7}, {8}}
+	if got := vectorGroups(testCases); !reflect.DeepEqual(got, want) {
+		t.Errorf("vectorGroups() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,637 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opendns/lemming/lib/log"
+)
+
+// Workload describes a multi-step benchmark read from a workload file.
+// All steps run inside a single lomax process sharing one connection pool,
+// and the results of every step end up in one combined report.
+//
+// Example:
+//
+//	{
+//	  "name": "new-hires",
+//	  "db": "employees",
+//	  "mode": "ordered",
+//	  "steps": [
+//	    {"name": "Hire", "action": "INSERT", "flag": "IGNORE", "table": "employees", "random": true, "count": 1000},
+//	    {"name": "Onboard", "action": "SELECT", "table": "employees", "columns": "*", "condition": "LIMIT 10", "count": 10}
+//	  ]
+//	}
+type Workload struct {
+	Name string `json:"name"`
+	DB   string `json:"db"`
+	// Mode is either "ordered" (the default), which runs every step count
+	// times one after the other, or "weighted", which runs count iterations
+	// in total and picks a step for each of them according to its weight.
+	Mode  string `json:"mode"`
+	Count int    `json:"count"`
+	// Threads sets the --threads option.  Every thread is a worker
+	// with its own connection that runs the whole workload; in weighted
+	// mode count is the number of iterations per worker.
+	Threads int `json:"threads"`
+	// Duration, e.g. "5m", runs the workload for a wall clock period
+	// instead of count iterations.  In ordered mode every step runs for
+	// the whole duration.
+	Duration string `json:"duration"`
+	// Rate is the target number of queries per second over all workers.
+	Rate  float64        `json:"rate"`
+	Steps []WorkloadStep `json:"steps"`
+	// Dataset is the data the workload runs on, see lomax prepare and
+	// lomax cleanup.
+	Dataset *Dataset `json:"dataset"`
+
+	duration time.Duration
+	// source is the file the workload was loaded from.
+	source string
+	// slots is the number of steps and transaction statements statistics
+	// are kept for.
+	slots int
+}
+
+// WorkloadStep is a single operation of a workload.  The fields mirror the
+// command line options of a single lomax run.  Instead of those, Query can
+// hold a complete statement.  Either way the statement may contain ?
+// placeholders, bound to values generated according to Params:
+//
+//	{"query": "SELECT * FROM employees WHERE emp_no = ?", "params": [{"type": "uniform", "min": 10001, "max": 499999}]}
+type WorkloadStep struct {
+	Name      string          `json:"name"`
+	Query     string          `json:"query"`
+	Params    []GeneratorSpec `json:"params"`
+	Action    string          `json:"action"`
+	Flag      string          `json:"flag"`
+	Table     string          `json:"table"`
+	Columns   string          `json:"columns"`
+	Condition string          `json:"condition"`
+	// Random generates the inserted rows from the table's schema.  Columns
+	// optionally restricts the generated columns, and Generators overrides
+	// how the values of individual columns are generated.
+	Random     bool                     `json:"random"`
+	Generators map[string]GeneratorSpec `json:"generators"`
+	// Bulk writes Batch generated rows per statement instead of one: with
+	// a multi-row INSERT ("insert"), INSERT ... ON DUPLICATE KEY UPDATE
+	// ("upsert"), REPLACE ("replace") or LOAD DATA LOCAL INFILE ("infile").
+	Bulk   string `json:"bulk"`
+	Batch  int    `json:"batch"`
+	Count  int    `json:"count"`
+	Weight int    `json:"weight"`
+	// Role runs the step on the --targets of that role, e.g. "replica".
+	Role string `json:"role"`
+	// Transaction turns the step into a transaction of several statements
+	// instead of a single one.
+	Transaction *Transaction `json:"transaction"`
+	// DDL turns the step into a schema change, run once on a connection of
+	// its own while the other steps keep running.  After is the delay
+	// between the start of the workload and the schema change.  Algorithm
+	// and Lock add the ALGORITHM and LOCK options of ALTER TABLE, CREATE
+	// INDEX and DROP INDEX.
+	DDL       string `json:"ddl"`
+	Algorithm string `json:"algorithm"`
+	Lock      string `json:"lock"`
+	After     string `json:"after"`
+
+	// slot is where the statistics of a transaction statement are kept.
+	slot int
+	// warned flags the error classes already logged for the step.
+	warned [errorClasses]int32
+	// query is the statement sent to the server, generator and params
+	// produce its arguments.
+	query       string
+	returnsRows bool
+	generator   *rowGenerator
+	params      []valueGenerator
+	// after is the parsed After, and change tracks the run of a DDL step.
+	after  time.Duration
+	change *schemaChange
+}
+
+const (
+	workloadOrdered  = "ordered"
+	workloadWeighted = "weighted"
+)
+
+var workloadFile string
+
+func init() {
+	flag.StringVar(&workloadFile, "workload", "", "Workload: Input a multi-step workload file to run in a single process.")
+}
+
+// label returns the name a step is reported under.
+func (step *WorkloadStep) label(index int) string {
+	return fmt.Sprintf("[%d] %s", index+1, step.describe())
+}
+
+func (step *WorkloadStep) describe() string {
+	if step.Name != "" {
+		return step.Name
+	}
+	if step.DDL != "" {
+		fields := strings.Fields(step.DDL)
+		if len(fields) > 3 {
+			fields = fields[:3]
+		}
+		return strings.Join(fields, " ")
+	}
+	if step.Table == "" {
+		return strings.ToUpper(step.Action)
+	}
+	if step.Bulk != "" {
+		return fmt.Sprintf("%s %s (%s x%d)", strings.ToUpper(step.Action), step.Table, step.Bulk, step.Batch)
+	}
+	return fmt.Sprintf("%s %s", strings.ToUpper(step.Action), step.Table)
+}
+
+// assignSlots numbers the statements of the workload's transactions.  Every
+// step keeps its statistics in the slot of its index, the statements of
+// transactions in the slots following the last step.
+func (workload *Workload) assignSlots() {
+	slot := len(workload.Steps)
+	for i := range workload.Steps {
+		if txn := workload.Steps[i].Transaction; txn != nil {
+			for j := range txn.Statements {
+				txn.Statements[j].slot = slot
+				slot++
+			}
+		}
+	}
+	workload.slots = slot
+}
+
+// parseWorkload decodes and validates a workload file.
+func parseWorkload(data []byte) (*Workload, error) {
+	var workload Workload
+	if err := json.Unmarshal(data, &workload); err != nil {
+		return nil, err
+	}
+
+	workload.Mode = strings.ToLower(workload.Mode)
+	if workload.Mode == "" {
+		workload.Mode = workloadOrdered
+	}
+	if workload.Mode != workloadOrdered && workload.Mode != workloadWeighted {
+		return nil, fmt.Errorf("invalid workload mode %q, must be %q or %q", workload.Mode, workloadOrdered, workloadWeighted)
+	}
+	if len(workload.Steps) == 0 {
+		return nil, fmt.Errorf("workload %q has no steps", workload.Name)
+	}
+	if workload.Duration != "" {
+		duration, err := time.ParseDuration(workload.Duration)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid workload duration %q", workload.Duration)
+		}
+		workload.duration = duration
+	}
+	if workload.Rate < 0 {
+		return nil, fmt.Errorf("invalid workload rate %v", workload.Rate)
+	}
+	if workload.Mode == workloadWeighted && workload.Count <= 0 && workload.duration == 0 {
+		return nil, fmt.Errorf("weighted workload %q needs a positive count or a duration", workload.Name)
+	}
+
+	if workload.Dataset != nil {
+		if err := workload.Dataset.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range workload.Steps {
+		step := &workload.Steps[i]
+		if err := step.validate(fmt.Sprintf("step %d", i+1)); err != nil {
+			return nil, err
+		}
+		if workload.Mode == workloadWeighted && step.Weight <= 0 && step.DDL == "" {
+			return nil, fmt.Errorf("step %d: weighted workloads need a positive weight", i+1)
+		}
+	}
+	ddl := 0
+	for i := range workload.Steps {
+		if workload.Steps[i].DDL != "" {
+			ddl++
+		}
+	}
+	if ddl == len(workload.Steps) {
+		return nil, fmt.Errorf("workload %q has no steps to run while its DDL runs", workload.Name)
+	}
+	workload.assignSlots()
+	return &workload, nil
+}
+
+// validate checks a step of a workload and fills in its defaults.  label
+// names the step in errors.
+func (step *WorkloadStep) validate(label string) error {
+	switch {
+	case step.DDL != "":
+		if step.Transaction != nil {
+			return fmt.Errorf("%s: a DDL step can not be a transaction", label)
+		}
+		if err := step.validateDDL(label); err != nil {
+			return err
+		}
+	case step.Transaction != nil:
+		if step.Query != "" || step.Action != "" || step.Table != "" || step.Random || len(step.Params) > 0 {
+			return fmt.Errorf("%s: a transaction step can only hold statements", label)
+		}
+		if err := step.Transaction.validate(label); err != nil {
+			return err
+		}
+		// The statements run on the connection of the transaction.
+		for j := range step.Transaction.Statements {
+			if step.Transaction.Statements[j].Role != "" {
+				return fmt.Errorf("%s.%d: the statements of a transaction run on the transaction's role", label, j+1)
+			}
+			step.Transaction.Statements[j].Role = step.Role
+		}
+		step.Action = actionTransaction
+	case step.Query != "":
+		if step.Random {
+			return fmt.Errorf("%s: random data can not be generated for a query", label)
+		}
+		if step.Action == "" {
+			step.Action = strings.Fields(step.Query)[0]
+		}
+	default:
+		switch strings.ToUpper(step.Action) {
+		case "SELECT", "INSERT", "UPDATE", "DELETE":
+		default:
+			return fmt.Errorf("%s: invalid action %q", label, step.Action)
+		}
+		if step.Table == "" {
+			return fmt.Errorf("%s: no table specified", label)
+		}
+	}
+	if err := step.validateBulk(label); err != nil {
+		return err
+	}
+	if err := checkParams(step.Params, step.Table); err != nil {
+		return fmt.Errorf("%s: %s", label, err.Error())
+	}
+	if step.Random && strings.ToUpper(step.Action) != "INSERT" {
+		return fmt.Errorf("%s: random data can only be generated for INSERT", label)
+	}
+	if len(step.Generators) > 0 && !step.Random {
+		return fmt.Errorf("%s: generators need \"random\": true", label)
+	}
+	if step.Count <= 0 {
+		step.Count = 1
+	}
+	return nil
+}
+
+func loadWorkload(name string) *Workload {
+	path, err := resolvePath(name, searchDirs("testvectors/workloads"))
+	if err != nil {
+		log.Error(fmt.Sprintf("Workload File IO Error: %s\n", err.Error()))
+	}
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Error(fmt.Sprintf("Workload File IO Error: %s\n", err.Error()))
+	}
+
+	workload, err := parseWorkload(file)
+	if err != nil {
+		log.Error("[%s]: %s: %s", GetFunctionName(loadWorkload), name, err.Error())
+	}
+	workload.source = path
+	return workload
+}
+
+// configLayer returns the options the workload sets: its db, threads,
+// duration and rate.
+func (workload *Workload) configLayer() configLayer {
+	layer := configLayer{source: workload.source}
+	if workload.DB != "" {
+		layer.config.DB = &workload.DB
+	}
+	if workload.Threads > 0 {
+		threads := float64(workload.Threads)
+		layer.config.Threads = &threads
+	}
+	if workload.duration > 0 {
+		d := duration(workload.duration)
+		layer.config.Duration = &d
+	}
+	if workload.Rate > 0 {
+		layer.config.Rate = &workload.Rate
+	}
+	return layer
+}
+
+// prepare builds the statement of the step and the generators of its
+// arguments.  Random rows are inserted through placeholders, so the step's
+// table is introspected here.
+func (step *WorkloadStep) prepare(db *sql.DB) {
+	if step.Transaction != nil {
+		for i := range step.Transaction.Statements {
+			step.Transaction.Statements[i].prepare(db)
+		}
+		return
+	}
+	if step.query != "" {
+		return
+	}
+
+	columns, condition := step.Columns, step.Condition
+	if step.Random {
+		gen, err := newRowGenerator(db, step.Table, step.Columns, step.Generators)
+		if err != nil {
+			log.Error("[%s]: %s: %s", GetFunctionName(newRowGenerator), step.Table, err.Error())
+		}
+		step.generator = gen
+		if step.Bulk != "" {
+			if err := step.prepareBulk(); err != nil {
+				log.Error("[%s]: %s", GetFunctionName((*WorkloadStep).prepareBulk), err.Error())
+			}
+			return
+		}
+		columns = gen.columnList()
+		condition = strings.TrimSuffix(strings.Repeat("?, ", len(gen.columns)), ", ")
+	}
+
+	for i, spec := range step.Params {
+		if key, ok := spec.keyRange(step.Table); ok {
+			min, max, err := discoverKeyRange(db, key)
+			if err != nil {
+				log.Error("[%s]: %s", GetFunctionName(discoverKeyRange), err.Error())
+			}
+			spec.Min, spec.Max = float64(min), float64(max)
+		}
+		param, err := newParamGenerator(i, spec)
+		if err != nil {
+			log.Error("[%s]: %s", GetFunctionName(newParamGenerator), err.Error())
+		}
+		step.params = append(step.params, param)
+	}
+
+	step.query = step.Query
+	if step.query == "" {
+		step.query = buildQuery(step.Action, step.Flag, columns, step.Table, condition)
+	}
+	step.returnsRows = returnsRows(step.query)
+}
+
+// returnsRows reports whether a statement returns a result set.
+func returnsRows(query string) bool {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "SHOW", "DESCRIBE", "EXPLAIN", "WITH":
+		return true
+	}
+	return false
+}
+
+// newParamGenerator builds the generator for the index'th ? placeholder.
+// Parameters have no column type, so bounds that are whole numbers produce
+// integers.
+func newParamGenerator(index int, spec GeneratorSpec) (valueGenerator, error) {
+	column := columnInfo{name: fmt.Sprintf("param %d", index+1), dataType: "double"}
+	if spec.Min == float64(int64(spec.Min)) && spec.Max == float64(int64(spec.Max)) {
+		column.dataType = "bigint"
+	}
+	generator, err := newValueGenerator(column, spec)
+	if err == nil && generator == nil {
+		err = fmt.Errorf("%s: no generator type specified", column.name)
+	}
+	return generator, err
+}
+
+// checkParams checks the params of a step on table.
+func checkParams(params []GeneratorSpec, table string) error {
+	for i, spec := range params {
+		if _, ok := spec.keyRange(table); ok {
+			// The range is only known once the key has been read.
+			spec.Min, spec.Max = 0, 1
+		}
+		if _, err := newParamGenerator(i, spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// benchmarkParams returns the generators of the --params option.
+func benchmarkParams() ([]GeneratorSpec, error) {
+	if paramsPtr == "" {
+		return nil, nil
+	}
+	var params []GeneratorSpec
+	if err := json.Unmarshal([]byte(paramsPtr), &params); err != nil {
+		return nil, err
+	}
+	return params, checkParams(params, tablePtr)
+}
+
+// pickStep chooses a step index proportionally to the step weights.
+func pickStep(steps []WorkloadStep, totalWeight int, rng *rand.Rand) int {
+	target := rng.Intn(totalWeight)
+	for i := range steps {
+		target -= steps[i].Weight
+		if target < 0 {
+			return i
+		}
+	}
+	return len(steps) - 1
+}
+
+func runWorkload(workload *Workload) {
+	// The workload's threads, duration and rate were merged into the
+	// options by loadConfig.
+	threads := int(threadPtr)
+	sched := schedule{duration: durationPtr, rate: ratePtr}
+	targets := configuredTargets()
+	if splitPtr {
+		splitRoles(workload.Steps)
+	}
+	if problems := checkRoles(workload.Steps, targets); len(problems) > 0 {
+		log.Error("[%s]: %s", GetFunctionName(runWorkload), strings.Join(problems, "; "))
+	}
+	fmt.Println(fmt.Sprintf("Running workload %q (%d steps, %s, %d workers), please wait...", workload.Name, len(workload.Steps), workload.Mode, threads))
+
+	if logPrefix == "" {
+		log.Warning(fmt.Sprintf("[%s]: No --logprefix defined, log file will NOT be created", GetFunctionName(exportData)))
+	}
+
+	// A fan-out runs a separate pool of workers against every target,
+	// otherwise the workers route the steps to the targets of their role.
+	var pools []*workerPool
+	if fanoutPtr {
+		for _, t := range targets {
+			pools = append(pools, newTargetPool(threads, workload.slots, dbPtr, []target{t}, nil))
+		}
+	} else {
+		pools = append(pools, newTargetPool(threads, workload.slots, dbPtr, targets, nil))
+	}
+	all := &workerPool{}
+	for _, pool := range pools {
+		defer pool.close()
+		all.workers = append(all.workers, pool.workers...)
+	}
+	for i := range workload.Steps {
+		workload.Steps[i].prepare(pools[0].workers[0].dbFor(&workload.Steps[i]))
+	}
+	for _, pool := range pools {
+		pool.prepare(workload.Steps)
+	}
+
+	elapsed := make([][]time.Duration, len(pools))
+	totals := make([]time.Duration, len(pools))
+	var memBefore, memAfter runtime.MemStats
+	runtime.ReadMemStats(&memBefore)
+	status := startStatusProbe("workload "+workload.Name, targets)
+	reporter := startIntervalReporter(all, intervalPtr, status)
+	lag := startLagMonitor(all, workload, targets, lagPollPtr)
+	ddl := startSchemaChanges(workload, all, targets[0])
+	start := time.Now()
+	var wg sync.WaitGroup
+	for p := range pools {
+		suffix := ""
+		if fanoutPtr {
+			suffix = fmt.Sprintf(" @%s", targets[p].name())
+		}
+		wg.Add(1)
+		go func(p int, suffix string) {
+			defer wg.Done()
+			elapsed[p], totals[p] = runWorkloadPhases(workload, pools[p], sched, suffix)
+		}(p, suffix)
+	}
+	wg.Wait()
+	ddl.wait()
+	total := totals[0]
+	if fanoutPtr {
+		total = time.Since(start)
+	}
+	lag.Stop()
+	reporter.Stop()
+	status.finish()
+	runtime.ReadMemStats(&memAfter)
+
+	// A step of the aggregate took as long as it did on the slowest
+	// target.
+	aggregate := make([]time.Duration, workload.slots)
+	for p, pool := range pools {
+		for i, d := range elapsed[p] {
+			if d > aggregate[i] {
+				aggregate[i] = d
+			}
+		}
+		if fanoutPtr {
+			reportWorkload(workload, pool.stats(), elapsed[p], totals[p], len(pool.workers), fmt.Sprintf(" @%s", targets[p].name()), "-", "-")
+		}
+	}
+	iterations := reportWorkload(workload, all.stats(), aggregate, total, len(all.workers), "",
+		fmt.Sprintf("%d", memAfter.Mallocs-memBefore.Mallocs), fmt.Sprintf("%d", memAfter.TotalAlloc-memBefore.TotalAlloc))
+	printData()
+	printThroughput(iterations, total, sched.rate*float64(len(pools)))
+}
+
+// runWorkloadPhases runs the workload on the workers of pool.  It returns the
+// time every step took and the time the whole workload took.
+func runWorkloadPhases(workload *Workload, pool *workerPool, sched schedule, suffix string) ([]time.Duration, time.Duration) {
+	elapsed := make([]time.Duration, workload.slots)
+	var total time.Duration
+
+	if workload.Mode == workloadWeighted {
+		totalWeight := 0
+		for _, step := range workload.Steps {
+			totalWeight += step.Weight
+		}
+		sched.iterations = workload.Count
+		total = pool.run(func(w *worker) {
+			w.loop(sched, len(pool.workers), func(intended time.Time) {
+				i := pickStep(workload.Steps, totalWeight, w.rng)
+				w.execStepAt(i, &workload.Steps[i], intended)
+			})
+		})
+		// Steps are interleaved, so a step's share of the run is the time
+		// the average worker spent executing it.
+		for i, stats := range pool.stats() {
+			elapsed[i] = stats.busy / time.Duration(len(pool.workers))
+		}
+		return elapsed, total
+	}
+
+	// Every step is a separate phase: all workers start a step together
+	// and the next step only begins once every worker has finished.
+	for i := range workload.Steps {
+		// DDL steps run next to the others, see startSchemaChanges.
+		if workload.Steps[i].DDL != "" {
+			continue
+		}
+		fmt.Println(fmt.Sprintf("[%s]: Running step %s%s", GetFunctionName(runWorkload), workload.Steps[i].label(i), suffix))
+		sched.iterations = workload.Steps[i].Count
+		elapsed[i] = pool.run(func(w *worker) {
+			w.loop(sched, len(pool.workers), func(intended time.Time) {
+				w.execStepAt(i, &workload.Steps[i], intended)
+			})
+		})
+		total += elapsed[i]
+	}
+	return elapsed, total
+}
+
+// reportWorkload adds the report rows of a workload run with stats and
+// returns its number of iterations.  suffix labels the rows, workers is the
+// number of workers that ran it.
+func reportWorkload(workload *Workload, stats []stepStats, elapsed []time.Duration, total time.Duration, workers int, suffix string, memAllocs string, memBytes string) int {
+	totalStats := newStepStats(1)[0]
+	var operations []string
+	operationElapsed := make(map[string]time.Duration)
+	operationStats := make(map[string]*stepStats)
+	addOperation := func(step *WorkloadStep, slot int) {
+		operation := strings.ToUpper(step.Action)
+		if _, ok := operationStats[operation]; !ok {
+			operations = append(operations, operation)
+			operationStats[operation] = &newStepStats(1)[0]
+		}
+		operationElapsed[operation] += elapsed[slot]
+		operationStats[operation].add(&stats[slot])
+	}
+	for i := range workload.Steps {
+		step := &workload.Steps[i]
+		if step.DDL != "" {
+			// The DDL ran once, on the first target.
+			if suffix == "" && step.change != nil {
+				collectSchemaChange(step.label(i), step.change)
+			}
+			continue
+		}
+		collectResult(step.label(i)+suffix, elapsed[i], stats[i].iterations, "-", "-", &stats[i])
+		if step.Bulk != "" {
+			collectBulk(step.label(i)+suffix, step, elapsed[i], &stats[i])
+		}
+		totalStats.add(&stats[i])
+		addOperation(step, i)
+		if step.Transaction == nil {
+			continue
+		}
+
+		collectTransaction(step.label(i)+suffix, &stats[i])
+		for j := range step.Transaction.Statements {
+			statement := &step.Transaction.Statements[j]
+			// Statements run interleaved within their transaction.
+			elapsed[statement.slot] = stats[statement.slot].busy / time.Duration(workers)
+			collectResult(fmt.Sprintf("[%d.%d] %s%s", i+1, j+1, statement.describe(), suffix), elapsed[statement.slot], stats[statement.slot].iterations, "-", "-", &stats[statement.slot])
+			addOperation(statement, statement.slot)
+		}
+	}
+	for _, operation := range operations {
+		collectResult(fmt.Sprintf("all %s steps%s", operation, suffix), operationElapsed[operation], operationStats[operation].iterations, "-", "-", operationStats[operation])
+	}
+	collectResult(fmt.Sprintf("workload %s%s", workload.Name, suffix), total, totalStats.iterations, memAllocs, memBytes, &totalStats)
+	return totalStats.iterations
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseWorkload(t *testing.T) {
+	cases := []struct {
+		input     string
+		wantError bool
+	}{
+		{`{"name": "ok", "steps": [{"action": "SELECT", "table": "employees", "columns": "*"}]}`, false},
+		{`{"name": "weighted", "mode": "weighted", "count": 10, "steps": [{"action": "select", "table": "employees", "weight": 3}]}`, false},
+		{`{"name": "nosteps", "steps": []}`, true},
+		{`{"name": "badmode", "mode": "random", "steps": [{"action": "SELECT", "table": "employees"}]}`, true},
+		{`{"name": "badaction", "steps": [{"action": "TRUNCATE", "table": "employees"}]}`, true},
+		{`{"name": "notable", "steps": [{"action": "SELECT"}]}`, true},
+		{`{"name": "noweight", "mode": "weighted", "count": 10, "steps": [{"action": "SELECT", "table": "employees"}]}`, true},
+		{`{"name": "nocount", "mode": "weighted", "steps": [{"action": "SELECT", "table": "employees", "weight": 1}]}`, true},
+		{`{"name": "query", "steps": [{"query": "SELECT * FROM employees WHERE emp_no = ?", "params": [{"type": "uniform", "min": 1, "max": 9}]}]}`, false},
+		{`{"name": "badparam", "steps": [{"query": "SELECT * FROM employees WHERE emp_no = ?", "params": [{"min": 1, "max": 9}]}]}`, true},
+		{`{"name": "randomquery", "steps": [{"query": "INSERT INTO t VALUES (1)", "random": true}]}`, true},
+		{`{"name": "randomselect", "steps": [{"action": "SELECT", "table": "employees", "random": true}]}`, true},
+		{`{"name": "bulk", "steps": [{"action": "INSERT", "table": "employees", "bulk": "infile"}]}`, false},
+		{`{"name": "bulkselect", "steps": [{"action": "SELECT", "table": "employees", "bulk": "insert"}]}`, true},
+	}
+	for _, c := range cases {
+		workload, err := parseWorkload([]byte(c.input))
+		if (err != nil) != c.wantError {
+			t.Errorf("parseWorkload(%s) returned error %v, want error %t", c.input, err, c.wantError)
+			continue
+		}
+		if err == nil && workload.Steps[0].Count != 1 {
+			t.Errorf("parseWorkload(%s) step count = %d, want default of 1", c.input, workload.Steps[0].Count)
+		}
+	}
+}
+
+func TestWorkloadFiles(t *testing.T) {
+	files, err := filepath.Glob("./testvectors/workloads/*.json")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no workload files found: %v", err)
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseWorkload(data); err != nil {
+			t.Errorf("%s: %s", file, err)
+		}
+	}
+}
+
+func TestStepPrepare(t *testing.T) {
+	cases := []struct {
+		step        WorkloadStep
+		query       string
+		returnsRows bool
+	}{
+		{WorkloadStep{Action: "SELECT", Columns: "*", Table: "employees", Condition: "WHERE emp_no = ?", Params: []GeneratorSpec{{Type: "uniform", Min: 1, Max: 10}}},
+			"SELECT  * FROM employees WHERE emp_no = ?", true},
+		{WorkloadStep{Action: "DELETE", Table: "titles", Condition: "emp_no = ?", Params: []GeneratorSpec{{Type: "sequence", Start: 1}}},
+			"DELETE  FROM titles WHERE emp_no = ?", false},
+		{WorkloadStep{Query: "show status like 'Threads%'"}, "show status like 'Threads%'", true},
+	}
+	for _, c := range cases {
+		c.step.prepare(nil)
+		if c.step.query != c.query || c.step.returnsRows != c.returnsRows || len(c.step.params) != len(c.step.Params) {
+			t.Errorf("prepare() built %q (rows %t, %d params), want %q (rows %t, %d params)",
+				c.step.query, c.step.returnsRows, len(c.step.params), c.query, c.returnsRows, len(c.step.Params))
+		}
+	}
+}
+
+func TestNewParamGenerator(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	integer, _ := newParamGenerator(0, GeneratorSpec{Type: "uniform", Min: 1, Max: 10})
+	if _, ok := integer.next(rng).(int64); !ok {
+		t.Errorf("whole number bounds did not generate an integer")
+	}
+	float, _ := newParamGenerator(0, GeneratorSpec{Type: "uniform", Min: 0.5, Max: 1})
+	if _, ok := float.next(rng).(float64); !ok {
+		t.Errorf("fractional bounds did not generate a float")
+	}
+}
+
+func TestPickStep(t *testing.T) {
+	steps := []WorkloadStep{{Weight: 1}, {Weight: 0}, {Weight: 3}}
+	rng := rand.New(rand.NewSource(1))
+	var picked [3]int
+	for i := 0; i < 4000; i++ {
+		picked[pickStep(steps, 4, rng)]++
+	}
+	if picked[1] != 0 {
+		t.Errorf("pickStep picked a zero weight step %d times", picked[1])
+	}
+	if picked[2] < 2*picked[0] {
+		t.Errorf("pickStep distribution %v does not follow the weights 1:0:3", picked)
+	}
+}